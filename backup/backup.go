@@ -0,0 +1,279 @@
+// Package backup writes consistent snapshots of the database while the
+// server is running and restores them. Snapshots are written in a read
+// transaction, so they don't block the writers.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/bbolt"
+	"github.com/pkg/errors"
+)
+
+// Scheduled backups are named by their creation time, so they sort by age
+const (
+	filePrefix = "gamechars-"
+	fileSuffix = ".db.gz"
+	timeFormat = "2006-01-02T15-04-05"
+)
+
+// openTimeout is how long opening a database waits for the file lock
+const openTimeout = time.Second
+
+// Write writes a snapshot of the database to w, gzip compressed if compress is set
+func Write(db *bolt.DB, w io.Writer, compress bool) error {
+	return db.View(func(tx *bolt.Tx) error {
+		if !compress {
+			_, err := tx.WriteTo(w)
+			return err
+		}
+
+		gz := gzip.NewWriter(w)
+		if _, err := tx.WriteTo(gz); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+// ErrServerNotRunning is returned by Download if no server accepts connections
+var ErrServerNotRunning = errors.New("The server is not running")
+
+// WriteFile writes a snapshot of the database to a file. Files ending in
+// .gz are compressed. The file only appears once the snapshot is complete.
+func WriteFile(db *bolt.DB, path string) error {
+	return writeFile(path, func(w io.Writer) error {
+		return Write(db, w, strings.HasSuffix(path, ".gz"))
+	})
+}
+
+// Download fetches a snapshot from the /admin/backup endpoint of a running
+// server and writes it to a file. The token authenticates the request as
+// an admin. Files ending in .gz are compressed.
+func Download(serverURL string, token string, path string) error {
+	u := strings.TrimSuffix(serverURL, "/") + "/admin/backup?gzip=" + strconv.FormatBool(strings.HasSuffix(path, ".gz"))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if stderrors.As(err, &opErr) && opErr.Op == "dial" {
+			return ErrServerNotRunning
+		}
+		return errors.Wrap(err, "Unable to download the backup")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("The server refused the backup: %s", res.Status)
+	}
+
+	return writeFile(path, func(w io.Writer) error {
+		_, err := io.Copy(w, res.Body)
+		return err
+	})
+}
+
+// writeFile writes a backup to a temporary file which replaces path once it is complete
+func writeFile(path string, write func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create the backup file")
+	}
+
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "Unable to write the backup")
+	}
+	return os.Rename(tmp, path)
+}
+
+// Handler streams a snapshot of the database. The snapshot is compressed
+// if the query parameter gzip is true.
+func Handler(db *bolt.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		compress, _ := strconv.ParseBool(r.URL.Query().Get("gzip"))
+		name := filePrefix + time.Now().Format(timeFormat) + ".db"
+		if compress {
+			name += ".gz"
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+		// The status is already sent once streaming started,
+		// so errors can only be logged
+		if err := Write(db, w, compress); err != nil {
+			fmt.Println(err.Error())
+		}
+	})
+}
+
+// Schedule writes a compressed backup into dir every interval and
+// deletes all but the newest keep backups.
+func Schedule(db *bolt.DB, dir string, interval time.Duration, keep int) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "Unable to create the backup directory")
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			path := filepath.Join(dir, filePrefix+time.Now().Format(timeFormat)+fileSuffix)
+			if err := WriteFile(db, path); err != nil {
+				fmt.Println(err.Error())
+				continue
+			}
+			fmt.Printf("Backup written to %s.\n", path)
+
+			if err := prune(dir, keep); err != nil {
+				fmt.Println(err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// prune deletes all but the newest keep scheduled backups in dir
+func prune(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read the backup directory")
+	}
+
+	var backups []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), filePrefix) && strings.HasSuffix(e.Name(), fileSuffix) {
+			backups = append(backups, e.Name())
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return errors.Wrapf(err, "Unable to delete the backup %s", name)
+		}
+	}
+	return nil
+}
+
+// OpenReadOnly opens a database without changing it. It fails if
+// another process, like a running server, has opened it for writing.
+func OpenReadOnly(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: openTimeout})
+}
+
+// Restore replaces the database at path with a backup, which may be
+// compressed. The backup is checked for consistency and passed to validate
+// before anything is replaced. The replaced database is kept with the
+// suffix .bak. The database must not be in use.
+func Restore(backup string, path string, validate func(db *bolt.DB) error) error {
+	tmp := path + ".restore"
+	if err := unpack(backup, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := check(tmp, validate); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		// Make sure no server is using the database
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+		if err != nil {
+			os.Remove(tmp)
+			return errors.Wrap(err, "The database is in use, stop the server first")
+		}
+		db.Close()
+
+		if err := os.Rename(path, path+".bak"); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+// unpack copies a backup to path and decompresses it if necessary
+func unpack(backup string, path string) error {
+	src, err := os.Open(backup)
+	if err != nil {
+		return errors.Wrap(err, "Unable to open the backup")
+	}
+	defer src.Close()
+
+	br := bufio.NewReader(src)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return errors.Wrap(err, "Unable to decompress the backup")
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Wrap(err, "Unable to unpack the backup")
+}
+
+// check opens the unpacked backup and verifies all its pages
+func check(path string, validate func(db *bolt.DB) error) error {
+	db, err := OpenReadOnly(path)
+	if err != nil {
+		return errors.Wrap(err, "The backup is not a valid database")
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		// Drain the channel, the check stops once it's done
+		var first error
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+	if err != nil {
+		return errors.Wrap(err, "The backup is corrupted")
+	}
+	if validate != nil {
+		return validate(db)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fusion44/gamechars-server/limits"
+	"github.com/fusion44/gamechars-server/persisted"
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// graphqlRequest is a single GraphQL operation as sent by the apollo client
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    struct {
+		PersistedQuery *persisted.Extension `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// batchHandler executes a single GraphQL operation or a batch of operations
+// as sent by clients which support batching like apollo client.
+// The operations of a batch are executed concurrently.
+type batchHandler struct {
+	Schema *graphql.Schema
+	// PersistedQueries resolves the hashes of persisted queries, may be nil
+	PersistedQueries *persisted.Store
+	// Limits rejects operations which are too complex
+	Limits *limits.Checker
+	// MaxBatchSize is the maximum number of operations in a batch, 0 means unlimited
+	MaxBatchSize int
+	// MaxBodySize is the maximum size of a request body in bytes, 0 means unlimited
+	MaxBodySize int64
+	// Timeout cancels operations which take longer, 0 means no timeout
+	Timeout time.Duration
+}
+
+// errorResponse creates a response with a single error in GraphQL format
+func errorResponse(format string, args ...interface{}) *graphql.Response {
+	return &graphql.Response{
+		Errors: []*gqlerrors.QueryError{gqlerrors.Errorf(format, args...)},
+	}
+}
+
+func (h *batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.MaxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxBodySize)
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("The request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Unable to decode request body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var requests []graphqlRequest
+	batch := len(body) > 0 && bytes.TrimSpace(body)[0] == '['
+	if batch {
+		if err := json.Unmarshal(body, &requests); err != nil {
+			http.Error(w, "Unable to decode request batch", http.StatusBadRequest)
+			return
+		}
+	} else {
+		var request graphqlRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			http.Error(w, "Unable to decode request", http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, request)
+	}
+
+	if h.MaxBatchSize > 0 && len(requests) > h.MaxBatchSize {
+		msg, _ := json.Marshal(errorResponse("Batch size %d exceeds the maximum of %d", len(requests), h.MaxBatchSize))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(msg)
+		return
+	}
+
+	ctx := r.Context()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	responses := make([]*graphql.Response, len(requests))
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = h.exec(ctx, requests[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var msg []byte
+	var err error
+	if batch {
+		msg, err = json.Marshal(responses)
+	} else {
+		msg, err = json.Marshal(responses[0])
+	}
+	if err != nil {
+		http.Error(w, "Error processing the request", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(msg)
+}
+
+// resolvePersistedQuery replaces the query of a request with the persisted
+// query of its hash. unknown is set for queries which aren't registered yet.
+func resolvePersistedQuery(store *persisted.Store, req *graphqlRequest) (bool, *gqlerrors.QueryError) {
+	if store == nil {
+		return false, nil
+	}
+
+	query, unknown, err := store.Resolve(req.Query, req.Extensions.PersistedQuery)
+	if err != nil {
+		queryErr := gqlerrors.Errorf("%s", err)
+		if err == persisted.ErrNotFound {
+			queryErr.Extensions = map[string]interface{}{"code": persisted.NotFoundCode}
+		}
+		return false, queryErr
+	}
+	req.Query = query
+	return unknown, nil
+}
+
+// checkRequest resolves the persisted query of a request and checks it
+// against the limits. Unknown persisted queries are only registered once
+// they are valid and within the limits, so clients can't fill the
+// database with queries which are never executed.
+func checkRequest(store *persisted.Store, checker *limits.Checker, req *graphqlRequest) []*gqlerrors.QueryError {
+	unknown, err := resolvePersistedQuery(store, req)
+	if err != nil {
+		return []*gqlerrors.QueryError{err}
+	}
+	if errs := checker.Check(req.Query, req.OperationName, req.Variables); len(errs) > 0 {
+		return errs
+	}
+
+	if unknown {
+		// Queries which can't be registered are still executed, the
+		// client just has to send them in full every time
+		if err := store.Register(req.Query); err != nil && err != persisted.ErrTooLarge {
+			fmt.Println(err.Error())
+		}
+	}
+	return nil
+}
+
+// exec resolves persisted queries, checks the limits of a single operation and executes it. If the
+// timeout expires the operation is abandoned. Resolvers which respect the
+// context stop early, all others finish in the background.
+func (h *batchHandler) exec(ctx context.Context, req graphqlRequest) *graphql.Response {
+	if errs := checkRequest(h.PersistedQueries, h.Limits, &req); len(errs) > 0 {
+		return &graphql.Response{Errors: errs}
+	}
+
+	done := make(chan *graphql.Response, 1)
+	go func() {
+		done <- h.Schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	}()
+
+	select {
+	case response := <-done:
+		return response
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return errorResponse("Request timed out after %s", h.Timeout)
+		}
+		return errorResponse("Request was canceled")
+	}
+}
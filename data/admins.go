@@ -0,0 +1,25 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/fusion44/gamechars-server/utils"
+)
+
+// admins may use the admin endpoints and queries
+var admins = make(map[string]bool)
+
+// SetAdmins sets the users which are allowed to administrate the server
+func SetAdmins(userNames []string) {
+	admins = make(map[string]bool)
+	for _, userName := range userNames {
+		if userName = strings.TrimSpace(userName); userName != "" {
+			admins[userName] = true
+		}
+	}
+}
+
+// IsAdmin checks if the logged in user is an admin
+func IsAdmin(auth utils.AuthData) bool {
+	return auth.Authenticated && admins[auth.UserName]
+}
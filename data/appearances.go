@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// appearance corresponds to the GQL type Appearance
+type appearance struct {
+	GameID graphql.ID
+	Role   string
+	Year   int32
+}
+
+// debutGame derives the game a character appeared in first from its
+// appearances. The game the character was created with counts as well.
+func (gc *gameCharacter) debutGame(ctx context.Context) *game {
+	return gc.debutGameFrom(func(id graphql.ID) *game {
+		return loadGame(ctx, id)
+	})
+}
+
+// debutGameFrom derives the debut game using the given function to look up games.
+// A year of 0 is unknown, any known year is earlier than an unknown one.
+func (gc *gameCharacter) debutGameFrom(lookup func(id graphql.ID) *game) *game {
+	debut := lookup(gc.GameID)
+	debutYear := int32(0)
+	if debut != nil {
+		debutYear = debut.ReleaseYear
+	}
+
+	for _, a := range gc.Appearances {
+		if debut == nil || (a.Year != 0 && (debutYear == 0 || a.Year < debutYear)) {
+			if g := lookup(a.GameID); g != nil {
+				debut = g
+				debutYear = a.Year
+			}
+		}
+	}
+	return debut
+}
+
+// appearedIn checks whether the character appeared in the given game
+func (gc *gameCharacter) appearedIn(gameID graphql.ID) bool {
+	if gc.GameID == gameID {
+		return true
+	}
+	for _, a := range gc.Appearances {
+		if a.GameID == gameID {
+			return true
+		}
+	}
+	return false
+}
+
+type appearanceResolver struct {
+	appearance *appearance
+}
+
+func (ar *appearanceResolver) Game(ctx context.Context) *gameResolver {
+	if g := loadGame(ctx, ar.appearance.GameID); g != nil {
+		return &gameResolver{g}
+	}
+	return nil
+}
+
+func (ar *appearanceResolver) Role() string {
+	return ar.appearance.Role
+}
+
+func (ar *appearanceResolver) Year() int32 {
+	return ar.appearance.Year
+}
+
+type appearanceInput struct {
+	GameID graphql.ID
+	Role   string
+	Year   *int32
+}
+
+// ownedCharacter returns the character with the given global ID if the logged in user owns it
+func ownedCharacter(ctx context.Context, id graphql.ID) (*gameCharacter, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := getCharacter(localID(characterKind, id))
+	if gc == nil || !auth.Authenticated || gc.Owner != auth.UserName {
+		return nil, errors.Errorf("Character %s not found", id)
+	}
+	return gc, nil
+}
+
+// AddAppearance adds a game to the appearances of a character.
+// If the character already appeared in the game, the appearance is updated.
+func (r *Resolver) AddAppearance(ctx context.Context, args *struct {
+	CharacterID graphql.ID
+	Version     int32
+	Appearance  *appearanceInput
+}) (*gameCharacterResolver, error) {
+	g := getGame(localID(gameKind, args.Appearance.GameID))
+	if g == nil {
+		return nil, errors.Errorf("Game %s does not exist", args.Appearance.GameID)
+	}
+
+	a := appearance{
+		GameID: g.ID,
+		Role:   args.Appearance.Role,
+		Year:   g.ReleaseYear,
+	}
+	if args.Appearance.Year != nil {
+		a.Year = *args.Appearance.Year
+	}
+
+	gc, err := updateOwnedCharacter(ctx, args.CharacterID, args.Version, func(tx *bolt.Tx, gc *gameCharacter) error {
+		for i := range gc.Appearances {
+			if gc.Appearances[i].GameID == a.GameID {
+				gc.Appearances[i] = a
+				return nil
+			}
+		}
+		gc.Appearances = append(gc.Appearances, a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gameCharacterResolver{gc}, nil
+}
+
+// RemoveAppearance removes a game from the appearances of a character
+func (r *Resolver) RemoveAppearance(ctx context.Context, args *struct {
+	CharacterID graphql.ID
+	Version     int32
+	GameID      graphql.ID
+}) (*gameCharacterResolver, error) {
+	gameID := localID(gameKind, args.GameID)
+	gc, err := updateOwnedCharacter(ctx, args.CharacterID, args.Version, func(tx *bolt.Tx, gc *gameCharacter) error {
+		for i := range gc.Appearances {
+			if gc.Appearances[i].GameID == gameID {
+				gc.Appearances = append(gc.Appearances[:i], gc.Appearances[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gameCharacterResolver{gc}, nil
+}
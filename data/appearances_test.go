@@ -0,0 +1,44 @@
+package data
+
+import (
+	"testing"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+func TestDebutGameFrom(t *testing.T) {
+	games := map[graphql.ID]*game{
+		"hl":      {ID: "hl", Title: "Half-Life", ReleaseYear: 1998},
+		"hl2":     {ID: "hl2", Title: "Half-Life 2", ReleaseYear: 2004},
+		"unknown": {ID: "unknown", Title: "Unknown"},
+	}
+	lookup := func(id graphql.ID) *game {
+		return games[id]
+	}
+
+	tests := []struct {
+		name        string
+		gameID      graphql.ID
+		appearances []appearance
+		want        graphql.ID
+	}{
+		{"only the game", "hl2", nil, "hl2"},
+		{"earlier appearance", "hl2", []appearance{{GameID: "hl", Year: 1998}}, "hl"},
+		{"later appearance", "hl", []appearance{{GameID: "hl2", Year: 2004}}, "hl"},
+		{"unknown release year", "unknown", []appearance{{GameID: "hl2", Year: 2004}}, "hl2"},
+		{"unknown appearance year", "hl2", []appearance{{GameID: "hl"}}, "hl2"},
+		{"unknown years only", "", []appearance{{GameID: "unknown"}, {GameID: "hl"}}, "unknown"},
+		{"known year after unknown", "", []appearance{{GameID: "unknown"}, {GameID: "hl2", Year: 2004}}, "hl2"},
+		{"missing game", "", nil, ""},
+	}
+	for _, test := range tests {
+		gc := &gameCharacter{GameID: test.gameID, Appearances: test.appearances}
+		var got graphql.ID
+		if debut := gc.debutGameFrom(lookup); debut != nil {
+			got = debut.ID
+		}
+		if got != test.want {
+			t.Errorf("%s: got debut game %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+var collectionsBucket = []byte("Collections")
+
+// collection corresponds to the GQL type Collection
+type collection struct {
+	ID          graphql.ID
+	Name        string
+	Description string
+	Public      bool
+	// CharacterIDs is ordered as chosen by the owner
+	CharacterIDs []graphql.ID
+	Owner        string
+}
+
+// visibleTo checks whether the user is allowed to see the collection.
+// The same rules as for characters apply.
+func (c *collection) visibleTo(auth utils.AuthData) bool {
+	return c.Public || c.Owner == auth.UserName
+}
+
+func getCollection(tx *bolt.Tx, id graphql.ID) (*collection, error) {
+	entry := tx.Bucket(collectionsBucket).Get([]byte(id))
+	if entry == nil {
+		return nil, nil
+	}
+	var c collection
+	if err := json.Unmarshal(entry, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func putCollection(tx *bolt.Tx, c *collection) error {
+	cJSON, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal collection to JSON")
+	}
+	return tx.Bucket(collectionsBucket).Put([]byte(c.ID), cJSON)
+}
+
+type collectionResolver struct {
+	collection *collection
+}
+
+func (cr *collectionResolver) ID() graphql.ID {
+	return globalID(collectionKind, cr.collection.ID)
+}
+
+func (cr *collectionResolver) Name() string {
+	return cr.collection.Name
+}
+
+func (cr *collectionResolver) Description() string {
+	return cr.collection.Description
+}
+
+func (cr *collectionResolver) Public() bool {
+	return cr.collection.Public
+}
+
+func (cr *collectionResolver) Owner() string {
+	return cr.collection.Owner
+}
+
+// Characters gets the characters of the collection in order.
+// Characters the user is not allowed to see are left out.
+func (cr *collectionResolver) Characters(ctx context.Context) []*gameCharacterResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	gameChars := []*gameCharacterResolver{}
+	db.View(func(tx *bolt.Tx) error {
+		for _, id := range cr.collection.CharacterIDs {
+			if gc := activeCharacter(tx, id); gc != nil && gc.visibleTo(auth) {
+				gameChars = append(gameChars, &gameCharacterResolver{gc})
+			}
+		}
+		return nil
+	})
+	return gameChars
+}
+
+// Collection gets one collection by its ID
+func (r *Resolver) Collection(ctx context.Context, args struct {
+	ID graphql.ID
+}) *collectionResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	var c *collection
+	db.View(func(tx *bolt.Tx) error {
+		c, err = getCollection(tx, localID(collectionKind, args.ID))
+		return err
+	})
+	if c != nil && c.visibleTo(auth) {
+		return &collectionResolver{c}
+	}
+	return nil
+}
+
+// Collections gets all collections of a user which are visible to the logged in user
+func (r *Resolver) Collections(ctx context.Context, args struct {
+	Owner string
+}) []*collectionResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	collections := []*collectionResolver{}
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(collectionsBucket).ForEach(func(k, v []byte) error {
+			var c collection
+			if err := json.Unmarshal(v, &c); err != nil {
+				fmt.Printf("Unable to read collection %s: %s\n", k, err)
+				return nil
+			}
+			if c.Owner == args.Owner && c.visibleTo(auth) {
+				collections = append(collections, &collectionResolver{&c})
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].collection.Name < collections[j].collection.Name
+	})
+	return collections
+}
+
+type collectionInput struct {
+	Name         string
+	Description  *string
+	Public       bool
+	CharacterIDs *[]graphql.ID
+}
+
+// applyInput copies the input to the collection. All characters must be visible to the user.
+func (c *collection) applyInput(tx *bolt.Tx, input *collectionInput, auth utils.AuthData) error {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return errors.New("The name of a collection must not be empty")
+	}
+
+	c.Name = name
+	c.Public = input.Public
+	c.Description = ""
+	if input.Description != nil {
+		c.Description = *input.Description
+	}
+
+	c.CharacterIDs = nil
+	if input.CharacterIDs != nil {
+		seen := make(map[graphql.ID]bool)
+		for _, id := range *input.CharacterIDs {
+			gc := activeCharacter(tx, localID(characterKind, id))
+			if gc == nil || !gc.visibleTo(auth) {
+				return errors.Errorf("Character %s not found", id)
+			}
+			if !seen[gc.ID] {
+				seen[gc.ID] = true
+				c.CharacterIDs = append(c.CharacterIDs, gc.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// CreateCollection creates a new collection owned by the logged in user
+func (r *Resolver) CreateCollection(ctx context.Context, args *struct {
+	Collection *collectionInput
+}) (*collectionResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !auth.Authenticated {
+		return nil, errors.New("Only logged in users can create collections")
+	}
+
+	c := &collection{
+		ID:    graphql.ID(xid.New().String()),
+		Owner: auth.UserName,
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := c.applyInput(tx, args.Collection, auth); err != nil {
+			return err
+		}
+		return putCollection(tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &collectionResolver{c}, nil
+}
+
+// UpdateCollection replaces name, description, visibility and characters of a collection
+func (r *Resolver) UpdateCollection(ctx context.Context, args *struct {
+	ID         graphql.ID
+	Collection *collectionInput
+}) (*collectionResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *collection
+	err = db.Update(func(tx *bolt.Tx) error {
+		c, err = getCollection(tx, localID(collectionKind, args.ID))
+		if err != nil {
+			return err
+		}
+		// Only the owner may change a collection
+		if c == nil || !auth.Authenticated || c.Owner != auth.UserName {
+			return errors.Errorf("Collection %s not found", args.ID)
+		}
+		if err := c.applyInput(tx, args.Collection, auth); err != nil {
+			return err
+		}
+		return putCollection(tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &collectionResolver{c}, nil
+}
+
+// DeleteCollection deletes a collection of the logged in user
+func (r *Resolver) DeleteCollection(ctx context.Context, args *struct {
+	ID graphql.ID
+}) *resultResolver {
+	res := result{
+		Op:    "delete",
+		Count: 0,
+	}
+
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+		return &resultResolver{&res}
+	}
+
+	db.Update(func(tx *bolt.Tx) error {
+		c, err := getCollection(tx, localID(collectionKind, args.ID))
+		if err != nil || c == nil {
+			return err
+		}
+		if auth.Authenticated && c.Owner == auth.UserName {
+			res.Count = 1
+			return tx.Bucket(collectionsBucket).Delete([]byte(c.ID))
+		}
+		return nil
+	})
+
+	return &resultResolver{&res}
+}
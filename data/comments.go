@@ -0,0 +1,400 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+// commentsBucket maps a comment ID to the comment
+var commentsBucket = []byte("Comments")
+
+// commentThreadsBucket holds one nested bucket per thread which contains
+// the IDs of all comments in it. Top level comments are in the thread of
+// their character, replies in the thread of their parent comment.
+// Comment IDs are xids which sort by creation time.
+var commentThreadsBucket = []byte("CommentThreads")
+
+const maxCommentLength = 5000
+const maxCommentPageSize = 100
+
+// moderators may delete any comment
+var moderators = make(map[string]bool)
+
+// SetModerators sets the users which are allowed to delete any comment
+func SetModerators(userNames []string) {
+	moderators = make(map[string]bool)
+	for _, userName := range userNames {
+		if userName = strings.TrimSpace(userName); userName != "" {
+			moderators[userName] = true
+		}
+	}
+}
+
+// comment corresponds to the GQL type Comment
+type comment struct {
+	ID          graphql.ID
+	CharacterID graphql.ID
+	ParentID    graphql.ID
+	Author      string
+	Body        string
+	CreatedAt   time.Time
+	EditedAt    time.Time
+	Deleted     bool
+}
+
+// threadID returns the ID of the thread the comment belongs to
+func (c *comment) threadID() graphql.ID {
+	if c.ParentID != "" {
+		return c.ParentID
+	}
+	return c.CharacterID
+}
+
+func getComment(tx *bolt.Tx, id graphql.ID) (*comment, error) {
+	entry := tx.Bucket(commentsBucket).Get([]byte(id))
+	if entry == nil {
+		return nil, nil
+	}
+	var c comment
+	if err := json.Unmarshal(entry, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func putComment(tx *bolt.Tx, c *comment) error {
+	cJSON, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal comment to JSON")
+	}
+	return tx.Bucket(commentsBucket).Put([]byte(c.ID), cJSON)
+}
+
+// commentPage loads up to first comments of a thread following the comment after
+func commentPage(threadID graphql.ID, first int32, after *graphql.ID) (*commentPageResolver, error) {
+	if first < 1 || first > maxCommentPageSize {
+		return nil, errors.Errorf("first must be between 1 and %d", maxCommentPageSize)
+	}
+
+	page := &commentPageResolver{}
+	err := db.View(func(tx *bolt.Tx) error {
+		thread := tx.Bucket(commentThreadsBucket).Bucket([]byte(threadID))
+		if thread == nil {
+			return nil
+		}
+
+		cursor := thread.Cursor()
+		k, _ := cursor.First()
+		if after != nil {
+			k, _ = cursor.Seek([]byte(*after))
+			if k != nil && string(k) == string(*after) {
+				k, _ = cursor.Next()
+			}
+		}
+
+		for ; k != nil; k, _ = cursor.Next() {
+			if len(page.comments) == int(first) {
+				page.hasNextPage = true
+				break
+			}
+			c, err := getComment(tx, graphql.ID(k))
+			if err != nil {
+				return err
+			}
+			if c != nil {
+				page.comments = append(page.comments, &commentResolver{c})
+			}
+		}
+		return nil
+	})
+	return page, err
+}
+
+// deleteCommentsOf permanently deletes all comments of a character
+func deleteCommentsOf(tx *bolt.Tx, id graphql.ID) error {
+	var toDelete []*comment
+	tx.Bucket(commentsBucket).ForEach(func(k, v []byte) error {
+		var c comment
+		if json.Unmarshal(v, &c) == nil && c.CharacterID == id {
+			toDelete = append(toDelete, &c)
+		}
+		return nil
+	})
+
+	threads := tx.Bucket(commentThreadsBucket)
+	if threads.Bucket([]byte(id)) != nil {
+		if err := threads.DeleteBucket([]byte(id)); err != nil {
+			return err
+		}
+	}
+	for _, c := range toDelete {
+		if threads.Bucket([]byte(c.ID)) != nil {
+			if err := threads.DeleteBucket([]byte(c.ID)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(commentsBucket).Delete([]byte(c.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCommentBody checks the length of a comment
+func validateCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || len(body) > maxCommentLength {
+		return "", errors.Errorf("Comments must be between 1 and %d characters long", maxCommentLength)
+	}
+	return body, nil
+}
+
+type commentResolver struct {
+	comment *comment
+}
+
+func (cr *commentResolver) ID() graphql.ID {
+	return cr.comment.ID
+}
+
+func (cr *commentResolver) ParentID() *graphql.ID {
+	if cr.comment.ParentID == "" {
+		return nil
+	}
+	return &cr.comment.ParentID
+}
+
+func (cr *commentResolver) Author() *string {
+	if cr.comment.Deleted {
+		return nil
+	}
+	return &cr.comment.Author
+}
+
+func (cr *commentResolver) Body() string {
+	if cr.comment.Deleted {
+		return ""
+	}
+	return cr.comment.Body
+}
+
+// HTML renders the markdown body of the comment. All HTML in the body is escaped.
+func (cr *commentResolver) HTML() string {
+	if cr.comment.Deleted {
+		return ""
+	}
+	return utils.RenderMarkdown(cr.comment.Body)
+}
+
+func (cr *commentResolver) CreatedAt() string {
+	return cr.comment.CreatedAt.Format(time.RFC3339)
+}
+
+func (cr *commentResolver) EditedAt() *string {
+	if cr.comment.EditedAt.IsZero() {
+		return nil
+	}
+	editedAt := cr.comment.EditedAt.Format(time.RFC3339)
+	return &editedAt
+}
+
+func (cr *commentResolver) Deleted() bool {
+	return cr.comment.Deleted
+}
+
+func (cr *commentResolver) Replies(args struct {
+	First int32
+	After *graphql.ID
+}) (*commentPageResolver, error) {
+	return commentPage(cr.comment.ID, args.First, args.After)
+}
+
+type commentPageResolver struct {
+	comments    []*commentResolver
+	hasNextPage bool
+}
+
+func (cpr *commentPageResolver) Comments() []*commentResolver {
+	if cpr.comments == nil {
+		return []*commentResolver{}
+	}
+	return cpr.comments
+}
+
+func (cpr *commentPageResolver) EndCursor() *graphql.ID {
+	if len(cpr.comments) == 0 {
+		return nil
+	}
+	return &cpr.comments[len(cpr.comments)-1].comment.ID
+}
+
+func (cpr *commentPageResolver) HasNextPage() bool {
+	return cpr.hasNextPage
+}
+
+// Comments gets the top level comments of the character. Comments are only
+// reachable through a character, so they are hidden along with the character
+// if it becomes private.
+func (gcr *gameCharacterResolver) Comments(args struct {
+	First int32
+	After *graphql.ID
+}) (*commentPageResolver, error) {
+	return commentPage(gcr.gameCharacter.ID, args.First, args.After)
+}
+
+// CreateComment adds a comment to a character visible to the logged in user.
+// If a parent is given, the comment is a reply to the parent comment.
+func (r *Resolver) CreateComment(ctx context.Context, args *struct {
+	CharacterID graphql.ID
+	Body        string
+	ParentID    *graphql.ID
+}) (*commentResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !auth.Authenticated {
+		return nil, errors.New("Only logged in users can comment")
+	}
+
+	gc := getCharacter(localID(characterKind, args.CharacterID))
+	if gc == nil || !gc.visibleTo(auth) {
+		return nil, errors.Errorf("Character %s not found", args.CharacterID)
+	}
+
+	body, err := validateCommentBody(args.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &comment{
+		ID:          graphql.ID(xid.New().String()),
+		CharacterID: gc.ID,
+		Author:      auth.UserName,
+		Body:        body,
+		CreatedAt:   time.Now(),
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if args.ParentID != nil {
+			parent, err := getComment(tx, *args.ParentID)
+			if err != nil {
+				return err
+			}
+			if parent == nil || parent.CharacterID != gc.ID {
+				return errors.Errorf("Comment %s not found", *args.ParentID)
+			}
+			c.ParentID = parent.ID
+		}
+
+		thread, err := tx.Bucket(commentThreadsBucket).CreateBucketIfNotExists([]byte(c.threadID()))
+		if err != nil {
+			return err
+		}
+		if err := thread.Put([]byte(c.ID), []byte{}); err != nil {
+			return err
+		}
+		return putComment(tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &commentResolver{c}, nil
+}
+
+// commentForUser loads a comment if its character is visible to the user
+func commentForUser(tx *bolt.Tx, id graphql.ID, auth utils.AuthData) (*comment, error) {
+	c, err := getComment(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.Errorf("Comment %s not found", id)
+	}
+	gc := activeCharacter(tx, c.CharacterID)
+	if gc == nil || !gc.visibleTo(auth) {
+		return nil, errors.Errorf("Comment %s not found", id)
+	}
+	return c, nil
+}
+
+// EditComment changes the body of a comment. Only the author may edit a comment.
+func (r *Resolver) EditComment(ctx context.Context, args *struct {
+	ID   graphql.ID
+	Body string
+}) (*commentResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !auth.Authenticated {
+		return nil, errors.New("Only logged in users can edit comments")
+	}
+
+	body, err := validateCommentBody(args.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *comment
+	err = db.Update(func(tx *bolt.Tx) error {
+		c, err = commentForUser(tx, args.ID, auth)
+		if err != nil {
+			return err
+		}
+		if c.Deleted || c.Author != auth.UserName {
+			return errors.Errorf("Comment %s can't be edited", args.ID)
+		}
+
+		c.Body = body
+		c.EditedAt = time.Now()
+		return putComment(tx, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &commentResolver{c}, nil
+}
+
+// DeleteComment deletes a comment. Authors may delete their own comments,
+// moderators may delete any comment. The comment is kept as a placeholder
+// so that replies stay in their thread.
+func (r *Resolver) DeleteComment(ctx context.Context, args *struct {
+	ID graphql.ID
+}) *resultResolver {
+	res := result{
+		Op:    "delete",
+		Count: 0,
+	}
+
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+		return &resultResolver{&res}
+	}
+
+	db.Update(func(tx *bolt.Tx) error {
+		var c *comment
+		if moderators[auth.UserName] && auth.Authenticated {
+			c, err = getComment(tx, args.ID)
+		} else {
+			c, err = commentForUser(tx, args.ID, auth)
+		}
+		if err != nil || c == nil || c.Deleted {
+			return nil
+		}
+
+		if auth.Authenticated && (c.Author == auth.UserName || moderators[auth.UserName]) {
+			c.Deleted = true
+			c.Body = ""
+			res.Count = 1
+			return putComment(tx, c)
+		}
+		return nil
+	})
+
+	return &resultResolver{&res}
+}
@@ -0,0 +1,29 @@
+package data
+
+import "github.com/fusion44/gamechars-server/limits"
+
+// FieldCosts are the costs of fields which are more expensive to resolve than
+// a simple lookup. They are used to estimate the complexity of queries.
+var FieldCosts = map[string]limits.FieldCost{
+	// Walks the relationship graph up to three edges deep
+	"GameCharacter.relationships":        {Cost: 10},
+	"PlayableCharacter.relationships":    {Cost: 10},
+	"NonPlayableCharacter.relationships": {Cost: 10},
+	"Boss.relationships":                 {Cost: 10},
+	// Compares the character with all others. Affordable for a single
+	// character, but a list multiplies it beyond the default limit.
+	"GameCharacter.possibleDuplicates":        {Cost: 1000},
+	"PlayableCharacter.possibleDuplicates":    {Cost: 1000},
+	"NonPlayableCharacter.possibleDuplicates": {Cost: 1000},
+	"Boss.possibleDuplicates":                 {Cost: 1000},
+	// Iterates over all characters
+	"Query.gameCharacters":      {Cost: 5},
+	"Query.tags":                {Cost: 5},
+	"Query.popularCharacters":   {Cost: 5},
+	"Query.brokenLinks":         {Cost: 5},
+	"Query.duplicateCharacters": {Cost: 5},
+	"Game.characters":           {Cost: 5},
+	// The size of a page is already accounted for by the first argument
+	// of the field which returned the page
+	"CommentPage.comments": {Multiplier: 1},
+}
@@ -0,0 +1,248 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// duplicateThreshold is the similarity from which two characters are
+// considered possible duplicates. Equal names reach it even if the debut
+// games differ, slightly different names only with the same debut game.
+const duplicateThreshold = 0.8
+
+// The weights of the name and the debut game in the similarity
+const (
+	nameWeight = 0.8
+	gameWeight = 0.2
+)
+
+// duplicateKey holds the normalized fields characters are compared by
+type duplicateKey struct {
+	name []rune
+	game string
+}
+
+// normalizeName converts a name for comparisons. Case, punctuation and
+// a leading "the" don't matter, "The Nameless One!" becomes "nameless one".
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > 1 && words[0] == "the" {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// duplicateKeyOf computes the key of a character, games are looked up with lookup
+func duplicateKeyOf(gc *gameCharacter, lookup func(id graphql.ID) *game) *duplicateKey {
+	game := gc.DebutGame
+	if debut := gc.debutGameFrom(lookup); debut != nil {
+		game = debut.Title
+	}
+	return &duplicateKey{[]rune(normalizeName(gc.Name)), normalizeName(game)}
+}
+
+// levenshtein returns the number of insertions, deletions and
+// substitutions needed to turn a into b
+func levenshtein(a []rune, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// similarity is 1 for characters with the same name and debut game and 0
+// for completely different ones. The names are compared fuzzily, so typos
+// like "Gordon Freemann" still match.
+func similarity(a *duplicateKey, b *duplicateKey) float64 {
+	longest := len(a.name)
+	if len(b.name) > longest {
+		longest = len(b.name)
+	}
+	if longest == 0 {
+		return 0
+	}
+	nameSimilarity := 1 - float64(levenshtein(a.name, b.name))/float64(longest)
+
+	gameSimilarity := 0.0
+	if a.game != "" && a.game == b.game {
+		gameSimilarity = 1
+	}
+	return nameWeight*nameSimilarity + gameWeight*gameSimilarity
+}
+
+// possibleDuplicate is a character similar to another one
+type possibleDuplicate struct {
+	gameCharacter *gameCharacter
+	similarity    float64
+}
+
+// findDuplicates returns the active characters visible to the user which
+// are similar to the given character, the most similar first
+func findDuplicates(tx *bolt.Tx, gc *gameCharacter, auth utils.AuthData) ([]*possibleDuplicate, error) {
+	lookup := cachedGames(tx)
+	key := duplicateKeyOf(gc, lookup)
+	var duplicates []*possibleDuplicate
+	err := forEachCharacter(tx, func(other *gameCharacter) error {
+		if other.ID == gc.ID || !other.DeletedAt.IsZero() || !other.visibleTo(auth) {
+			return nil
+		}
+		if s := similarity(key, duplicateKeyOf(other, lookup)); s >= duplicateThreshold {
+			duplicates = append(duplicates, &possibleDuplicate{other, s})
+		}
+		return nil
+	})
+
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return duplicates[i].similarity > duplicates[j].similarity
+	})
+	return duplicates, err
+}
+
+// duplicateClusters groups all active characters which are possible
+// duplicates of each other. Similarity is transitive here, if A is similar
+// to B and B to C, all three are in the same cluster.
+func duplicateClusters(tx *bolt.Tx) ([][]*gameCharacter, error) {
+	var characters []*gameCharacter
+	var keys []*duplicateKey
+	lookup := cachedGames(tx)
+	err := forEachCharacter(tx, func(gc *gameCharacter) error {
+		if gc.DeletedAt.IsZero() {
+			characters = append(characters, gc)
+			keys = append(keys, duplicateKeyOf(gc, lookup))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Union find over the indexes of the characters
+	parent := make([]int, len(characters))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range characters {
+		for j := i + 1; j < len(characters); j++ {
+			if similarity(keys[i], keys[j]) >= duplicateThreshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	members := make(map[int][]*gameCharacter)
+	var roots []int
+	for i, gc := range characters {
+		root := find(i)
+		if members[root] == nil {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], gc)
+	}
+
+	var clusters [][]*gameCharacter
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			clusters = append(clusters, members[root])
+		}
+	}
+	return clusters, nil
+}
+
+func (gcr *gameCharacterResolver) PossibleDuplicates(ctx context.Context) ([]*possibleDuplicateResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	var duplicates []*possibleDuplicate
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		duplicates, err = findDuplicates(tx, gcr.gameCharacter, auth)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := []*possibleDuplicateResolver{}
+	for _, d := range duplicates {
+		resolvers = append(resolvers, &possibleDuplicateResolver{d})
+	}
+	return resolvers, nil
+}
+
+// DuplicateCharacters lists groups of active characters which are probably the same
+func (r *Resolver) DuplicateCharacters(ctx context.Context) ([][]*gameCharacterResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !IsAdmin(auth) {
+		return nil, errors.New("Only admins can list duplicate characters")
+	}
+
+	var clusters [][]*gameCharacter
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		clusters, err = duplicateClusters(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := [][]*gameCharacterResolver{}
+	for _, cluster := range clusters {
+		var gameChars []*gameCharacterResolver
+		for _, gc := range cluster {
+			gameChars = append(gameChars, &gameCharacterResolver{gc})
+		}
+		result = append(result, gameChars)
+	}
+	return result, nil
+}
+
+type possibleDuplicateResolver struct {
+	duplicate *possibleDuplicate
+}
+
+func (pdr *possibleDuplicateResolver) Character() *gameCharacterResolver {
+	return &gameCharacterResolver{pdr.duplicate.gameCharacter}
+}
+
+func (pdr *possibleDuplicateResolver) Similarity() float64 {
+	return pdr.duplicate.similarity
+}
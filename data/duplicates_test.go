@@ -0,0 +1,120 @@
+package data
+
+import (
+	"testing"
+
+	bolt "github.com/coreos/bbolt"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Gordon Freeman", "gordon freeman"},
+		{"The Nameless One!", "nameless one"},
+		{"  GLaDOS ", "glados"},
+		{"The", "the"},
+		{"Theodore", "theodore"},
+		{"HK-47", "hk 47"},
+	}
+	for _, test := range tests {
+		if got := normalizeName(test.name); got != test.want {
+			t.Errorf("normalizeName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	key := func(name string, game string) *duplicateKey {
+		return &duplicateKey{[]rune(normalizeName(name)), normalizeName(game)}
+	}
+
+	tests := []struct {
+		name      string
+		a         *duplicateKey
+		b         *duplicateKey
+		duplicate bool
+	}{
+		{"typo", key("Gordon Freeman", "Half-Life"), key("Gordon Freemann", "Half-Life"), true},
+		{"the prefix", key("The Nameless One", "Planescape: Torment"), key("Nameless One", "Planescape: Torment"), true},
+		{"same name, different game", key("Alyx Vance", "Half-Life 2"), key("Alyx Vance", "Half-Life: Alyx"), true},
+		{"different name, same game", key("Alyx Vance", "Half-Life 2"), key("Eli Vance", "Half-Life 2"), false},
+		{"typo, different game", key("Gordon Freeman", "Half-Life"), key("Gordon Freemann", "Half-Life 2"), false},
+		{"unknown games", key("Chell", ""), key("Chell", ""), true},
+	}
+	for _, test := range tests {
+		s := similarity(test.a, test.b)
+		if duplicate := s >= duplicateThreshold; duplicate != test.duplicate {
+			t.Errorf("%s: got similarity %.2f, want duplicate %t", test.name, s, test.duplicate)
+		}
+		if s != similarity(test.b, test.a) {
+			t.Errorf("%s: the similarity is not symmetric", test.name)
+		}
+	}
+}
+
+// TestDuplicateClustersAreTransitive checks that characters end up in one
+// cluster if they are only similar through another character
+func TestDuplicateClustersAreTransitive(t *testing.T) {
+	openTestDB(t)
+
+	// a and c are only similar through b, d is similar to none of them
+	characters := []struct {
+		id   graphql.ID
+		name string
+		game string
+	}{
+		{"a", "Sarah Kerrigan", "StarCraft"},
+		{"b", "Sarah Kerrigan", "Heroes of the Storm"},
+		{"c", "Sara Kerrigan", "Heroes of the Storm"},
+		{"d", "Jim Raynor", "StarCraft"},
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, c := range characters {
+			g, err := gameForDebut(tx, c.game, 0)
+			if err != nil {
+				return err
+			}
+			if err := putCharacter(tx, &gameCharacter{ID: c.id, Name: c.name, GameID: g.ID, Version: 1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var clusters [][]*gameCharacter
+	err = db.View(func(tx *bolt.Tx) error {
+		lookup := cachedGames(tx)
+		a, c := readCharacter(tx, "a"), readCharacter(tx, "c")
+		if similarity(duplicateKeyOf(a, lookup), duplicateKeyOf(c, lookup)) >= duplicateThreshold {
+			t.Error("the first and the third character are similar on their own")
+		}
+
+		var err error
+		clusters, err = duplicateClusters(tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cluster := range clusters {
+		ids := make(map[graphql.ID]bool)
+		for _, gc := range cluster {
+			ids[gc.ID] = true
+		}
+		if !ids["a"] && !ids["b"] && !ids["c"] && !ids["d"] {
+			continue
+		}
+		if len(ids) != 3 || !ids["a"] || !ids["b"] || !ids["c"] {
+			t.Errorf("got cluster %v, want a, b and c", ids)
+		}
+		return
+	}
+	t.Error("a, b and c are not clustered")
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// Topics for character events
+const (
+	topicCharacterAdded   = "characterAdded"
+	topicCharacterUpdated = "characterUpdated"
+	topicCharacterRemoved = "characterRemoved"
+)
+
+// subscriberBufferSize is the number of events buffered per subscriber.
+// Events for subscribers which don't keep up are dropped, so a slow
+// client can never block a mutation.
+const subscriberBufferSize = 16
+
+// broker is an in-process pub/sub broker for character events
+type broker struct {
+	mutex       sync.RWMutex
+	subscribers map[string]map[chan *gameCharacter]bool
+}
+
+var characterEvents = &broker{
+	subscribers: make(map[string]map[chan *gameCharacter]bool),
+}
+
+func (b *broker) subscribe(topic string) chan *gameCharacter {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch := make(chan *gameCharacter, subscriberBufferSize)
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[chan *gameCharacter]bool)
+	}
+	b.subscribers[topic][ch] = true
+	return ch
+}
+
+func (b *broker) unsubscribe(topic string, ch chan *gameCharacter) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.subscribers[topic], ch)
+}
+
+func (b *broker) publish(topic string, gc *gameCharacter) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for ch := range b.subscribers[topic] {
+		select {
+		case ch <- gc:
+		default:
+			fmt.Printf("Dropped %s event for a slow subscriber.\n", topic)
+		}
+	}
+}
+
+// subscribeCharacters forwards all events of a topic which are visible to
+// the user to the returned channel until the context is cancelled
+func subscribeCharacters(ctx context.Context, topic string) <-chan *gameCharacter {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	events := characterEvents.subscribe(topic)
+	visible := make(chan *gameCharacter)
+	go func() {
+		defer close(visible)
+		defer characterEvents.unsubscribe(topic, events)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case gc := <-events:
+				if !gc.visibleTo(auth) {
+					continue
+				}
+				select {
+				case visible <- gc:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return visible
+}
+
+// characterResolvers wraps every character of a topic in a resolver
+func characterResolvers(ctx context.Context, topic string) <-chan *gameCharacterResolver {
+	resolvers := make(chan *gameCharacterResolver)
+	go func() {
+		defer close(resolvers)
+		for gc := range subscribeCharacters(ctx, topic) {
+			select {
+			case resolvers <- &gameCharacterResolver{gc}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return resolvers
+}
+
+// CharacterAdded notifies about new characters visible to the user
+func (r *Resolver) CharacterAdded(ctx context.Context) <-chan *gameCharacterResolver {
+	return characterResolvers(ctx, topicCharacterAdded)
+}
+
+// CharacterUpdated notifies about changes of characters visible to the user
+func (r *Resolver) CharacterUpdated(ctx context.Context) <-chan *gameCharacterResolver {
+	return characterResolvers(ctx, topicCharacterUpdated)
+}
+
+// CharacterRemoved notifies about the IDs of removed characters visible to the user
+func (r *Resolver) CharacterRemoved(ctx context.Context) <-chan graphql.ID {
+	ids := make(chan graphql.ID)
+	go func() {
+		defer close(ids)
+		for gc := range subscribeCharacters(ctx, topicCharacterRemoved) {
+			select {
+			case ids <- globalID(characterKind, gc.ID):
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ids
+}
@@ -0,0 +1,512 @@
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// The formats of an export
+const (
+	ExportJSON   = "json"
+	ExportCSV    = "csv"
+	ExportJSONLD = "jsonld"
+)
+
+// exportContentTypes maps the export formats to their content types
+var exportContentTypes = map[string]string{
+	ExportJSON:   "application/json",
+	ExportCSV:    "text/csv; charset=utf-8",
+	ExportJSONLD: "application/ld+json",
+}
+
+// jsonLDContext maps the fields to schema.org. Fields schema.org has
+// no vocabulary for use the gc prefix.
+var jsonLDContext = map[string]string{
+	"@vocab": "https://schema.org/",
+	"gc":     "https://github.com/fusion44/gamechars-server#",
+}
+
+// exportCSVColumns are the columns of a CSV export. Lists are separated by semicolons.
+var exportCSVColumns = []string{
+	"id", "name", "kind", "debutGame", "releaseYear", "gameId", "appearances", "tags", "favoriteCount",
+	"img", "desc", "wiki", "public", "owner", "version", "classes", "faction",
+	"bossHealth", "bossPhases", "bossWeaknesses", "creator", "source", "sourceRevision",
+	"relationships", "comments", "wikiLinkOk", "imgLinkOk", "mergedCharacters",
+}
+
+// exportPageSize is the number of characters read in one transaction. The
+// characters are written between the transactions, so slow clients don't
+// keep a transaction open.
+const exportPageSize = 100
+
+// exportedCharacter has the fields of the GQL type GameCharacter. Relationships
+// and comments are connections to other objects, they are exported as IDs.
+type exportedCharacter struct {
+	ID            graphql.ID           `json:"id"`
+	Name          string               `json:"name"`
+	Kind          string               `json:"kind"`
+	DebutGame     string               `json:"debutGame"`
+	ReleaseYear   int32                `json:"releaseYear"`
+	Game          *exportedGame        `json:"game"`
+	Appearances   []exportedAppearance `json:"appearances"`
+	Tags          []string             `json:"tags"`
+	FavoriteCount int32                `json:"favoriteCount"`
+	Img           string               `json:"img"`
+	Desc          string               `json:"desc"`
+	Wiki          string               `json:"wiki"`
+	Public        bool                 `json:"public"`
+	Owner         string               `json:"owner"`
+	Version       int32                `json:"version"`
+	Creator       string               `json:"creator"`
+	Provenance    *exportedProvenance  `json:"provenance"`
+	// Relationships are the IDs of the relationships from or to the character
+	Relationships []graphql.ID `json:"relationships"`
+	// Comments are the IDs of the top level comments, oldest first
+	Comments   []graphql.ID        `json:"comments"`
+	LinkStatus *exportedLinkStatus `json:"linkStatus"`
+	// MergedCharacters are the former IDs of the merged duplicates
+	MergedCharacters []graphql.ID       `json:"mergedCharacters"`
+	Classes          []string           `json:"classes,omitempty"`
+	Faction          *string            `json:"faction,omitempty"`
+	Stats            *exportedBossStats `json:"stats,omitempty"`
+}
+
+type exportedBossStats struct {
+	Health     *int32   `json:"health"`
+	Phases     int32    `json:"phases"`
+	Weaknesses []string `json:"weaknesses"`
+}
+
+type exportedProvenance struct {
+	Page         string `json:"page"`
+	URL          string `json:"url"`
+	Revision     string `json:"revision"`
+	RevisionDate string `json:"revisionDate"`
+	ImportedAt   string `json:"importedAt"`
+}
+
+// exportedLinkStatus has the last checks of the links, nil if they weren't
+// checked. Errors are only shown to admins, so they aren't exported.
+type exportedLinkStatus struct {
+	Wiki *exportedLinkCheck `json:"wiki"`
+	Img  *exportedLinkCheck `json:"img"`
+}
+
+type exportedLinkCheck struct {
+	URL       string `json:"url"`
+	OK        bool   `json:"ok"`
+	Status    *int32 `json:"status"`
+	CheckedAt string `json:"checkedAt"`
+}
+
+type exportedGame struct {
+	ID          graphql.ID `json:"id"`
+	Title       string     `json:"title"`
+	ReleaseYear int32      `json:"releaseYear"`
+}
+
+type exportedAppearance struct {
+	Game *exportedGame `json:"game"`
+	Role string        `json:"role"`
+	Year int32         `json:"year"`
+}
+
+// ExportContentType returns the content type of an export format.
+// Returns false if the format is unknown.
+func ExportContentType(format string) (string, bool) {
+	contentType, ok := exportContentTypes[format]
+	return contentType, ok
+}
+
+func exportGame(g *game) *exportedGame {
+	if g == nil {
+		return nil
+	}
+	return &exportedGame{globalID(gameKind, g.ID), g.Title, g.ReleaseYear}
+}
+
+func exportLinkCheck(tx *bolt.Tx, link string) *exportedLinkCheck {
+	if !checkableURL(link) {
+		return nil
+	}
+	result := readLinkStatus(tx, link)
+	if result == nil {
+		return nil
+	}
+
+	check := &exportedLinkCheck{
+		URL:       result.URL,
+		OK:        result.OK(),
+		CheckedAt: result.CheckedAt.Format(time.RFC3339),
+	}
+	if result.StatusCode != 0 {
+		status := int32(result.StatusCode)
+		check.Status = &status
+	}
+	return check
+}
+
+// exportCharacter collects the fields of a character like its resolver
+// does. Only relationships to characters visible to the user are exported.
+func exportCharacter(tx *bolt.Tx, gc *gameCharacter, lookup func(id graphql.ID) *game, auth utils.AuthData) *exportedCharacter {
+	e := &exportedCharacter{
+		ID:            globalID(characterKind, gc.ID),
+		Name:          gc.Name,
+		Kind:          gc.kind(),
+		DebutGame:     gc.DebutGame,
+		ReleaseYear:   gc.ReleaseYear,
+		Appearances:   []exportedAppearance{},
+		Tags:          readCharacterTags(tx, gc.ID),
+		FavoriteCount: int32(getFavoriteCount(tx, gc.ID)),
+		Img:           gc.Img,
+		Desc:          gc.Desc,
+		Wiki:          gc.Wiki,
+		Public:        gc.Public,
+		Owner:         gc.Owner,
+		Version:       gc.Version,
+		Creator:       gc.Creator,
+		Relationships: []graphql.ID{},
+		Comments:      []graphql.ID{},
+		LinkStatus: &exportedLinkStatus{
+			Wiki: exportLinkCheck(tx, gc.Wiki),
+			Img:  exportLinkCheck(tx, gc.Img),
+		},
+		MergedCharacters: []graphql.ID{},
+	}
+	for _, m := range gc.Merged {
+		e.MergedCharacters = append(e.MergedCharacters, globalID(characterKind, m.ID))
+	}
+	for _, rel := range visibleRelationships(tx, gc.ID, 1, auth) {
+		e.Relationships = append(e.Relationships, rel.ID)
+	}
+	if thread := tx.Bucket(commentThreadsBucket).Bucket([]byte(gc.ID)); thread != nil {
+		thread.ForEach(func(k, v []byte) error {
+			if c, err := getComment(tx, graphql.ID(k)); err == nil && c != nil {
+				e.Comments = append(e.Comments, c.ID)
+			}
+			return nil
+		})
+	}
+	if gc.Source != nil {
+		e.Provenance = &exportedProvenance{
+			Page:         gc.Source.Page,
+			URL:          gc.Source.URL,
+			Revision:     gc.Source.Revision,
+			RevisionDate: gc.Source.RevisionDate.Format(time.RFC3339),
+			ImportedAt:   gc.Source.ImportedAt.Format(time.RFC3339),
+		}
+	}
+	if debut := gc.debutGameFrom(lookup); debut != nil {
+		e.DebutGame = debut.Title
+		e.ReleaseYear = debut.ReleaseYear
+		e.Game = exportGame(debut)
+	}
+	for _, a := range gc.Appearances {
+		e.Appearances = append(e.Appearances, exportedAppearance{exportGame(lookup(a.GameID)), a.Role, a.Year})
+	}
+	if e.Tags == nil {
+		e.Tags = []string{}
+	}
+
+	switch e.Kind {
+	case kindPlayable:
+		e.Classes = gc.Classes
+		if e.Classes == nil {
+			e.Classes = []string{}
+		}
+	case kindBoss:
+		e.Stats = &exportedBossStats{Phases: 1, Weaknesses: []string{}}
+		if gc.Boss != nil {
+			e.Stats.Health = gc.Boss.Health
+			e.Stats.Phases = gc.Boss.Phases
+			if gc.Boss.Weaknesses != nil {
+				e.Stats.Weaknesses = gc.Boss.Weaknesses
+			}
+		}
+		fallthrough
+	case kindNonPlayable:
+		if gc.Faction != "" {
+			faction := gc.Faction
+			e.Faction = &faction
+		}
+	}
+	return e
+}
+
+// ExportCharacters writes all characters visible to the user, or only the
+// ones owned by the user if own is set. Characters in the trash aren't exported.
+func ExportCharacters(w io.Writer, format string, auth utils.AuthData, own bool) error {
+	var write func(e *exportedCharacter) error
+	var finish func() error
+
+	switch format {
+	case ExportJSON:
+		write, finish = jsonExporter(w)
+	case ExportCSV:
+		write, finish = csvExporter(w)
+	case ExportJSONLD:
+		write, finish = jsonLDExporter(w)
+	default:
+		return errors.Errorf("Unknown export format %s", format)
+	}
+
+	var after []byte
+	for {
+		var page []*exportedCharacter
+		var last []byte
+		err := db.View(func(tx *bolt.Tx) error {
+			page, last = nil, nil
+			lookup := cachedGames(tx)
+			c := tx.Bucket(gameCharactersBucket).Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if k != nil && string(k) == string(after) {
+					k, v = c.Next()
+				}
+			}
+
+			for n := 0; k != nil && n < exportPageSize; k, v = c.Next() {
+				n++
+				last = append([]byte{}, k...)
+				var gc gameCharacter
+				if err := json.Unmarshal(v, &gc); err != nil {
+					fmt.Printf("Unable to read character %s: %s\n", k, err)
+					continue
+				}
+				if !gc.DeletedAt.IsZero() || !gc.visibleTo(auth) || (own && gc.Owner != auth.UserName) {
+					continue
+				}
+				page = append(page, exportCharacter(tx, &gc, lookup, auth))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, e := range page {
+			if err := write(e); err != nil {
+				return err
+			}
+		}
+		if last == nil {
+			return finish()
+		}
+		after = last
+	}
+}
+
+// jsonArrayExporter writes the converted characters as a JSON array between prefix and suffix
+func jsonArrayExporter(w io.Writer, prefix string, suffix string, convert func(e *exportedCharacter) interface{}) (func(e *exportedCharacter) error, func() error) {
+	first := true
+	write := func(e *exportedCharacter) error {
+		sep := ","
+		if first {
+			sep = prefix + "["
+			first = false
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(convert(e))
+	}
+	finish := func() error {
+		end := "]" + suffix
+		if first {
+			end = prefix + "[]" + suffix
+		}
+		_, err := io.WriteString(w, end)
+		return err
+	}
+	return write, finish
+}
+
+// jsonExporter writes an array of characters
+func jsonExporter(w io.Writer) (func(e *exportedCharacter) error, func() error) {
+	return jsonArrayExporter(w, "", "\n", func(e *exportedCharacter) interface{} {
+		return e
+	})
+}
+
+// csvExporter writes a header followed by one row per character
+func csvExporter(w io.Writer) (func(e *exportedCharacter) error, func() error) {
+	cw := csv.NewWriter(w)
+	header := false
+	list := func(items []string) string {
+		return strings.Join(items, ";")
+	}
+
+	write := func(e *exportedCharacter) error {
+		if !header {
+			header = true
+			if err := cw.Write(exportCSVColumns); err != nil {
+				return err
+			}
+		}
+
+		var gameID, faction, bossHealth, bossPhases, bossWeaknesses, source, sourceRevision string
+		if e.Game != nil {
+			gameID = string(e.Game.ID)
+		}
+		if e.Faction != nil {
+			faction = *e.Faction
+		}
+		if e.Stats != nil {
+			if e.Stats.Health != nil {
+				bossHealth = strconv.Itoa(int(*e.Stats.Health))
+			}
+			bossPhases = strconv.Itoa(int(e.Stats.Phases))
+			bossWeaknesses = list(e.Stats.Weaknesses)
+		}
+		if e.Provenance != nil {
+			source = e.Provenance.URL
+			sourceRevision = e.Provenance.Revision
+		}
+		var wikiLinkOK, imgLinkOK string
+		if e.LinkStatus.Wiki != nil {
+			wikiLinkOK = strconv.FormatBool(e.LinkStatus.Wiki.OK)
+		}
+		if e.LinkStatus.Img != nil {
+			imgLinkOK = strconv.FormatBool(e.LinkStatus.Img.OK)
+		}
+		ids := func(ids []graphql.ID) string {
+			var s []string
+			for _, id := range ids {
+				s = append(s, string(id))
+			}
+			return list(s)
+		}
+		var appearances []string
+		for _, a := range e.Appearances {
+			if a.Game != nil {
+				appearances = append(appearances, fmt.Sprintf("%s (%s, %d)", a.Game.Title, a.Role, a.Year))
+			}
+		}
+
+		return cw.Write([]string{
+			string(e.ID), e.Name, e.Kind, e.DebutGame, strconv.Itoa(int(e.ReleaseYear)), gameID,
+			list(appearances), list(e.Tags), strconv.Itoa(int(e.FavoriteCount)),
+			e.Img, e.Desc, e.Wiki, strconv.FormatBool(e.Public), e.Owner, strconv.Itoa(int(e.Version)),
+			list(e.Classes), faction, bossHealth, bossPhases, bossWeaknesses,
+			e.Creator, source, sourceRevision,
+			ids(e.Relationships), ids(e.Comments), wikiLinkOK, imgLinkOK, ids(e.MergedCharacters),
+		})
+	}
+	finish := func() error {
+		if !header {
+			if err := cw.Write(exportCSVColumns); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return write, finish
+}
+
+// jsonLDExporter writes a graph of schema.org Persons. The games they
+// appeared in are VideoGames which have the person as character.
+func jsonLDExporter(w io.Writer) (func(e *exportedCharacter) error, func() error) {
+	ctx, _ := json.Marshal(jsonLDContext)
+	prefix := fmt.Sprintf("{\"@context\":%s,\"@graph\":", ctx)
+	return jsonArrayExporter(w, prefix, "}\n", jsonLDPerson)
+}
+
+func jsonLDVideoGame(g *exportedGame) map[string]interface{} {
+	vg := map[string]interface{}{
+		"@type":      "VideoGame",
+		"identifier": g.ID,
+		"name":       g.Title,
+	}
+	if g.ReleaseYear > 0 {
+		vg["datePublished"] = strconv.Itoa(int(g.ReleaseYear))
+	}
+	return vg
+}
+
+func jsonLDPerson(e *exportedCharacter) interface{} {
+	person := map[string]interface{}{
+		"@type":            "Person",
+		"identifier":       e.ID,
+		"name":             e.Name,
+		"description":      e.Desc,
+		"gc:kind":          e.Kind,
+		"gc:tags":          e.Tags,
+		"gc:favoriteCount": e.FavoriteCount,
+		"gc:public":        e.Public,
+		"gc:owner":         e.Owner,
+		"gc:version":       e.Version,
+		"gc:relationships": e.Relationships,
+		"gc:comments":      e.Comments,
+		"gc:linkStatus":    e.LinkStatus,
+		"gc:merged":        e.MergedCharacters,
+	}
+	if e.Img != "" {
+		person["image"] = e.Img
+	}
+	if e.Wiki != "" {
+		person["sameAs"] = e.Wiki
+	}
+	if len(e.Classes) > 0 {
+		person["jobTitle"] = e.Classes
+	}
+	if e.Faction != nil {
+		person["affiliation"] = map[string]interface{}{"@type": "Organization", "name": *e.Faction}
+	}
+	if e.Stats != nil {
+		person["gc:stats"] = e.Stats
+	}
+	if e.Creator != "" {
+		person["gc:creator"] = e.Creator
+	}
+	if e.Provenance != nil {
+		person["subjectOf"] = map[string]interface{}{
+			"@type":        "Article",
+			"url":          e.Provenance.URL,
+			"name":         e.Provenance.Page,
+			"version":      e.Provenance.Revision,
+			"dateModified": e.Provenance.RevisionDate,
+		}
+	}
+
+	// The debut game and the games of all appearances, each game once
+	var games, appearances []interface{}
+	seen := make(map[graphql.ID]bool)
+	if e.Game != nil {
+		person["gc:debutGame"] = e.Game.ID
+		seen[e.Game.ID] = true
+		games = append(games, jsonLDVideoGame(e.Game))
+	}
+	for _, a := range e.Appearances {
+		if a.Game == nil {
+			continue
+		}
+		appearances = append(appearances, map[string]interface{}{
+			"gc:game": a.Game.ID,
+			"gc:role": a.Role,
+			"gc:year": a.Year,
+		})
+		if !seen[a.Game.ID] {
+			seen[a.Game.ID] = true
+			games = append(games, jsonLDVideoGame(a.Game))
+		}
+	}
+	if len(games) > 0 {
+		person["@reverse"] = map[string]interface{}{"character": games}
+	}
+	if len(appearances) > 0 {
+		person["gc:appearances"] = appearances
+	}
+	return person
+}
@@ -0,0 +1,235 @@
+package data
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// favoritesBucket holds one nested bucket per user which maps
+// the IDs of the favorite characters to the time they were added
+var favoritesBucket = []byte("Favorites")
+
+// favoriteCountsBucket maps a character ID to the number of users
+// who marked it as favorite. It is updated in the same transaction
+// as the favorites bucket, so both always agree.
+var favoriteCountsBucket = []byte("FavoriteCounts")
+
+const defaultPopularLimit = 10
+
+// maxPopularLimit is the most popular characters returned at once
+const maxPopularLimit = 100
+
+func getFavoriteCount(tx *bolt.Tx, id graphql.ID) uint64 {
+	v := tx.Bucket(favoriteCountsBucket).Get([]byte(id))
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func putFavoriteCount(tx *bolt.Tx, id graphql.ID, count uint64) error {
+	if count == 0 {
+		return tx.Bucket(favoriteCountsBucket).Delete([]byte(id))
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, count)
+	return tx.Bucket(favoriteCountsBucket).Put([]byte(id), v)
+}
+
+// favoriteCount returns how many users marked the character as favorite
+func favoriteCount(id graphql.ID) int32 {
+	var count uint64
+	db.View(func(tx *bolt.Tx) error {
+		count = getFavoriteCount(tx, id)
+		return nil
+	})
+	return int32(count)
+}
+
+// setFavorite adds or removes a character from the favorites of a
+// user and updates the counter. Repeated calls don't change the count.
+func setFavorite(userName string, id graphql.ID, favorite bool) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		userFavorites, err := tx.Bucket(favoritesBucket).CreateBucketIfNotExists([]byte(userName))
+		if err != nil {
+			return err
+		}
+
+		isFavorite := userFavorites.Get([]byte(id)) != nil
+		if isFavorite == favorite {
+			return nil
+		}
+
+		count := getFavoriteCount(tx, id)
+		if favorite {
+			addedAt, _ := time.Now().MarshalText()
+			if err := userFavorites.Put([]byte(id), addedAt); err != nil {
+				return err
+			}
+			count++
+		} else {
+			if err := userFavorites.Delete([]byte(id)); err != nil {
+				return err
+			}
+			if count > 0 {
+				count--
+			}
+		}
+		return putFavoriteCount(tx, id, count)
+	})
+}
+
+// deleteFavoritesOf removes a character from the favorites of all users
+func deleteFavoritesOf(tx *bolt.Tx, id graphql.ID) error {
+	favorites := tx.Bucket(favoritesBucket)
+	err := favorites.ForEach(func(userName, v []byte) error {
+		if b := favorites.Bucket(userName); b != nil {
+			return b.Delete([]byte(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return putFavoriteCount(tx, id, 0)
+}
+
+func (gcr *gameCharacterResolver) FavoriteCount(ctx context.Context) int32 {
+	return loadFavoriteCount(ctx, gcr.gameCharacter.ID)
+}
+
+// FavoriteCharacter adds a character to the favorites of the logged in user
+func (r *Resolver) FavoriteCharacter(ctx context.Context, args *struct {
+	ID graphql.ID
+}) (*gameCharacterResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !auth.Authenticated {
+		return nil, errors.New("Only logged in users can add favorites")
+	}
+
+	gc := getCharacter(localID(characterKind, args.ID))
+	if gc == nil || !gc.visibleTo(auth) {
+		return nil, errors.Errorf("Character %s not found", args.ID)
+	}
+
+	if err := setFavorite(auth.UserName, gc.ID, true); err != nil {
+		return nil, err
+	}
+	forgetCharacter(ctx, gc.ID)
+	return &gameCharacterResolver{gc}, nil
+}
+
+// UnfavoriteCharacter removes a character from the favorites of the logged in user
+func (r *Resolver) UnfavoriteCharacter(ctx context.Context, args *struct {
+	ID graphql.ID
+}) (*gameCharacterResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !auth.Authenticated {
+		return nil, errors.New("Only logged in users can remove favorites")
+	}
+
+	// Characters which were removed in the meantime can still be unfavored
+	id := localID(characterKind, args.ID)
+	if err := setFavorite(auth.UserName, id, false); err != nil {
+		return nil, err
+	}
+	forgetCharacter(ctx, id)
+	if gc := getCharacter(id); gc != nil && gc.visibleTo(auth) {
+		return &gameCharacterResolver{gc}, nil
+	}
+	return nil, nil
+}
+
+// Favorites gets the favorite characters of the logged in user, newest first
+func (r *Resolver) Favorites(ctx context.Context) []*gameCharacterResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	type favorite struct {
+		gc      *gameCharacter
+		addedAt time.Time
+	}
+	var favorites []favorite
+	db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(favoritesBucket).Bucket([]byte(auth.UserName))
+		if !auth.Authenticated || b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			gc := activeCharacter(tx, graphql.ID(k))
+			if gc == nil || !gc.visibleTo(auth) {
+				return nil
+			}
+			var addedAt time.Time
+			addedAt.UnmarshalText(v)
+			favorites = append(favorites, favorite{gc, addedAt})
+			return nil
+		})
+	})
+
+	sort.Slice(favorites, func(i, j int) bool {
+		return favorites[i].addedAt.After(favorites[j].addedAt)
+	})
+
+	gameChars := []*gameCharacterResolver{}
+	for _, f := range favorites {
+		gameChars = append(gameChars, &gameCharacterResolver{f.gc})
+	}
+	return gameChars
+}
+
+// PopularCharacters gets the characters visible to the user ordered by their favorite count
+func (r *Resolver) PopularCharacters(ctx context.Context, args struct {
+	Limit int32
+}) []*gameCharacterResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	limit := int(args.Limit)
+	if limit < 0 {
+		limit = defaultPopularLimit
+	}
+	if limit > maxPopularLimit {
+		limit = maxPopularLimit
+	}
+
+	type popular struct {
+		gc    *gameCharacter
+		count uint64
+	}
+	var characters []popular
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(favoriteCountsBucket).ForEach(func(k, v []byte) error {
+			gc := activeCharacter(tx, graphql.ID(k))
+			if gc != nil && gc.visibleTo(auth) {
+				characters = append(characters, popular{gc, getFavoriteCount(tx, gc.ID)})
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(characters, func(i, j int) bool {
+		if characters[i].count == characters[j].count {
+			return characters[i].gc.Name < characters[j].gc.Name
+		}
+		return characters[i].count > characters[j].count
+	})
+
+	gameChars := []*gameCharacterResolver{}
+	for i := 0; i < len(characters) && i < limit; i++ {
+		gameChars = append(gameChars, &gameCharacterResolver{characters[i].gc})
+	}
+	return gameChars
+}
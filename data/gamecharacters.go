@@ -3,16 +3,23 @@ package data
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	bolt "github.com/coreos/bbolt"
 	"github.com/fusion44/gamechars-server/utils"
-	graphql "github.com/neelance/graphql-go"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
 	"github.com/rs/xid"
 )
 
 // gameCharacter corresponds to the GQL type GameCharacter.
 type gameCharacter struct {
-	ID          graphql.ID
-	Name        string
+	ID     graphql.ID
+	Name   string
+	GameID graphql.ID
+	// DebutGame and ReleaseYear are only used for data that was created
+	// before games were introduced. migrateDebutGames converts them to games.
 	DebutGame   string
 	ReleaseYear int32
 	Img         string
@@ -20,6 +27,28 @@ type gameCharacter struct {
 	Wiki        string
 	Public      bool
 	Owner       string
+	Creator     string
+	// Source is set for characters imported from a wiki, see ImportWikiPages
+	Source *provenance
+	// Merged records the characters merged into this one, see MergeCharacters
+	Merged []*mergeRecord
+	// Appearances lists all games the character appeared in
+	Appearances []appearance
+	// DeletedAt is set when the character was moved to the trash
+	DeletedAt time.Time
+	// Version is increased with every change, see checkVersion
+	Version int32
+	// Kind selects the concrete GQL type, the fields below depend on it
+	Kind    string
+	Classes []string
+	Faction string
+	Boss    *bossStats
+}
+
+// visibleTo checks whether the user is allowed to see the character.
+// Only public characters or characters owned by the user are visible.
+func (gc *gameCharacter) visibleTo(auth utils.AuthData) bool {
+	return gc.Public || gc.Owner == auth.UserName
 }
 
 type result struct {
@@ -27,7 +56,7 @@ type result struct {
 	Count int32
 }
 
-// gameCharacters some hardcoded data to work with
+// gameCharacters some hardcoded data to work with, see seedCharacters
 var gameCharacters = []*gameCharacter{
 	{
 		ID:          "1000",
@@ -39,6 +68,7 @@ var gameCharacters = []*gameCharacter{
 		Wiki:        "https://en.wikipedia.org/wiki/Gordon_Freeman",
 		Public:      true,
 		Owner:       "fusion44",
+		Kind:        kindPlayable,
 	},
 	{
 		ID:          "1001",
@@ -50,6 +80,9 @@ var gameCharacters = []*gameCharacter{
 		Wiki:        "https://en.wikipedia.org/wiki/GLaDOS",
 		Public:      true,
 		Owner:       "fusion44",
+		Kind:        kindBoss,
+		Faction:     "Aperture Science",
+		Boss:        &bossStats{Phases: 4, Weaknesses: []string{"Personality cores"}},
 	},
 	{
 		ID:          "1002",
@@ -61,6 +94,9 @@ var gameCharacters = []*gameCharacter{
 		Wiki:        "https://en.wikipedia.org/wiki/SHODAN",
 		Public:      true,
 		Owner:       "fusion44",
+		Kind:        kindBoss,
+		Faction:     "TriOptimum",
+		Boss:        &bossStats{Phases: 2, Weaknesses: []string{"Cyberspace"}},
 	},
 	{
 		ID:          "1003",
@@ -72,6 +108,8 @@ var gameCharacters = []*gameCharacter{
 		Wiki:        "https://en.wikipedia.org/wiki/The_Nameless_One",
 		Public:      true,
 		Owner:       "fusion44",
+		Kind:        kindPlayable,
+		Classes:     []string{"Fighter", "Mage", "Thief"},
 	}, {
 		ID:          "1004",
 		Name:        "Lara Croft",
@@ -82,6 +120,7 @@ var gameCharacters = []*gameCharacter{
 		Wiki:        "https://en.wikipedia.org/wiki/Lara_Croft",
 		Public:      true,
 		Owner:       "fusion44",
+		Kind:        kindPlayable,
 	}, {
 		ID:          "1005",
 		Name:        "Cate Archer",
@@ -92,15 +131,17 @@ var gameCharacters = []*gameCharacter{
 		Wiki:        "https://en.wikipedia.org/wiki/Cate_Archer",
 		Public:      true,
 		Owner:       "fusion44",
+		Kind:        kindPlayable,
 	},
 }
 
-var gameCharacterData = make(map[graphql.ID]*gameCharacter)
+// db is the database used by all resolvers
+var db *bolt.DB
 
-func init() {
-	for _, h := range gameCharacters {
-		gameCharacterData[h.ID] = h
-	}
+// SetDB sets the database the resolvers work with.
+// It must be called before the server starts handling requests.
+func SetDB(database *bolt.DB) {
+	db = database
 }
 
 // Resolver type holds all the specialized resolvers that implement GQL queries and mutations
@@ -127,31 +168,50 @@ func (r *Resolver) GameCharacter(ctx context.Context, args struct {
 		fmt.Println(err.Error())
 	}
 
-	if gc := gameCharacterData[args.ID]; gc != nil {
+	if gc := getCharacter(localID(characterKind, args.ID)); gc != nil {
 		// only return the data if the character data is public
 		// or the currently logged in user is marked as owner
-		if gc.Public || gc.Owner == auth.UserName {
+		if gc.visibleTo(auth) {
 			return &gameCharacterResolver{gc}
 		}
 	}
 	return nil
 }
 
-// GameCharacters gets all characters in the database
-func (r *Resolver) GameCharacters(ctx context.Context) *[]*gameCharacterResolver {
+// GameCharacters gets all characters in the database.
+// If tags are given, only characters having all of them are returned.
+func (r *Resolver) GameCharacters(ctx context.Context, args struct {
+	Tags *[]string
+}) (*[]*gameCharacterResolver, error) {
 	auth, err := utils.GetContextAuthData(ctx)
 	if err != nil {
 		fmt.Println(err.Error())
 	}
 
+	var tagged map[graphql.ID]bool
+	if args.Tags != nil && len(*args.Tags) > 0 {
+		var tags []string
+		for _, t := range *args.Tags {
+			normalized, err := normalizeTag(t)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, normalized)
+		}
+		tagged = taggedCharacterIDs(tags)
+	}
+
 	var gameChars []*gameCharacterResolver
-	for _, gc := range gameCharacterData {
-		if gc.Public || gc.Owner == auth.UserName {
+	for _, gc := range allCharacters() {
+		if tagged != nil && !tagged[gc.ID] {
+			continue
+		}
+		if gc.visibleTo(auth) {
 			gameChars = append(gameChars, &gameCharacterResolver{gc})
 		}
 	}
 
-	return &gameChars
+	return &gameChars, nil
 }
 
 // gameCharacterResolver resolves individual fields of a game character
@@ -160,21 +220,42 @@ type gameCharacterResolver struct {
 }
 
 func (gcr *gameCharacterResolver) ID() graphql.ID {
-	return gcr.gameCharacter.ID
+	return globalID(characterKind, gcr.gameCharacter.ID)
 }
 
 func (gcr *gameCharacterResolver) Name() string {
 	return gcr.gameCharacter.Name
 }
 
-func (gcr *gameCharacterResolver) DebutGame() string {
+func (gcr *gameCharacterResolver) DebutGame(ctx context.Context) string {
+	if g := gcr.gameCharacter.debutGame(ctx); g != nil {
+		return g.Title
+	}
 	return gcr.gameCharacter.DebutGame
 }
 
-func (gcr *gameCharacterResolver) ReleaseYear() int32 {
+func (gcr *gameCharacterResolver) ReleaseYear(ctx context.Context) int32 {
+	if g := gcr.gameCharacter.debutGame(ctx); g != nil {
+		return g.ReleaseYear
+	}
 	return gcr.gameCharacter.ReleaseYear
 }
 
+func (gcr *gameCharacterResolver) Game(ctx context.Context) *gameResolver {
+	if g := gcr.gameCharacter.debutGame(ctx); g != nil {
+		return &gameResolver{g}
+	}
+	return nil
+}
+
+func (gcr *gameCharacterResolver) Appearances() []*appearanceResolver {
+	var appearances []*appearanceResolver
+	for i := range gcr.gameCharacter.Appearances {
+		appearances = append(appearances, &appearanceResolver{&gcr.gameCharacter.Appearances[i]})
+	}
+	return appearances
+}
+
 func (gcr *gameCharacterResolver) Img() string {
 	return gcr.gameCharacter.Img
 }
@@ -195,12 +276,35 @@ func (gcr *gameCharacterResolver) Owner() string {
 	return gcr.gameCharacter.Owner
 }
 
+func (gcr *gameCharacterResolver) DeletedAt() *string {
+	if gcr.gameCharacter.DeletedAt.IsZero() {
+		return nil
+	}
+	deletedAt := gcr.gameCharacter.DeletedAt.Format(time.RFC3339)
+	return &deletedAt
+}
+
+func (gcr *gameCharacterResolver) Version() int32 {
+	return gcr.gameCharacter.Version
+}
+
+func (gcr *gameCharacterResolver) Creator() string {
+	return gcr.gameCharacter.Creator
+}
+
+func (gcr *gameCharacterResolver) Provenance() *provenanceResolver {
+	if gcr.gameCharacter.Source == nil {
+		return nil
+	}
+	return &provenanceResolver{gcr.gameCharacter.Source}
+}
+
 type userResolver struct {
 	user *user
 }
 
 func (u *userResolver) ID() graphql.ID {
-	return u.user.ID
+	return globalID(userKind, u.user.ID)
 }
 
 func (u *userResolver) UserName() string {
@@ -234,37 +338,74 @@ type UserDbModel struct {
 // CHARACTERS
 type gameCharacterInput struct {
 	Name        string
-	DebutGame   string
-	ReleaseYear int32
+	GameID      *graphql.ID
+	DebutGame   *string
+	ReleaseYear *int32
 	Img         string
 	Desc        string
 	Wiki        string
 	Public      bool
 	Owner       string
+	Kind        *string
+	Classes     *[]string
+	Faction     *string
+	BossStats   *bossStatsInput
+}
+
+// newCharacter validates the input and creates a character from it. The game
+// of the character is created if necessary, the character isn't stored yet.
+func newCharacter(tx *bolt.Tx, input *gameCharacterInput) (*gameCharacter, error) {
+	if strings.TrimSpace(input.Name) == "" {
+		return nil, errors.New("The name of a character is required")
+	}
+
+	gameID, err := gameIDForInput(tx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	gc := &gameCharacter{
+		ID:      graphql.ID(xid.New().String()),
+		Name:    input.Name,
+		GameID:  gameID,
+		Img:     input.Img,
+		Desc:    input.Desc,
+		Wiki:    input.Wiki,
+		Public:  input.Public,
+		Owner:   input.Owner,
+		Version: 1,
+	}
+	if err := gc.applyKind(input); err != nil {
+		return nil, err
+	}
+	return gc, nil
 }
 
 // AddCharacter Adds a new character to the database
 func (r *Resolver) AddCharacter(args *struct {
 	Char *gameCharacterInput // Argument name must be the same as in GQL
-}) *gameCharacterResolver {
-	gc := &gameCharacter{
-		ID:          graphql.ID(xid.New().String()),
-		Name:        args.Char.Name,
-		DebutGame:   args.Char.DebutGame,
-		ReleaseYear: args.Char.ReleaseYear,
-		Img:         args.Char.Img,
-		Desc:        args.Char.Desc,
-		Wiki:        args.Char.Wiki,
-		Public:      args.Char.Public,
-		Owner:       args.Char.Owner,
+}) (*gameCharacterResolver, error) {
+	var gc *gameCharacter
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		gc, err = newCharacter(tx, args.Char)
+		if err != nil {
+			return err
+		}
+		return putCharacter(tx, gc)
+	})
+	if err != nil {
+		return nil, err
 	}
-	gameCharacterData[gc.ID] = gc
-	return &gameCharacterResolver{gc}
+	characterEvents.publish(topicCharacterAdded, gc)
+	return &gameCharacterResolver{gc}, nil
 }
 
+// RemoveCharacter moves a character to the trash
 func (r *Resolver) RemoveCharacter(ctx context.Context, args *struct {
-	ID graphql.ID
-}) *resultResolver {
+	ID      graphql.ID
+	Version int32
+}) (*resultResolver, error) {
 	res := result{
 		Op:    "delete",
 		Count: 0,
@@ -274,23 +415,23 @@ func (r *Resolver) RemoveCharacter(ctx context.Context, args *struct {
 	auth, err := utils.GetContextAuthData(ctx)
 	if err != nil {
 		fmt.Println(err.Error())
-		return &resultResolver{&res}
+		return &resultResolver{&res}, nil
 	}
-
-	// Check if there is data to delete
-	before := len(gameCharacterData)
-	if before < 1 {
-		fmt.Println("No characters to delete")
-		return &resultResolver{&res}
+	if !auth.Authenticated {
+		return &resultResolver{&res}, nil
 	}
 
-	if gc := gameCharacterData[args.ID]; gc != nil {
-		// Check the first
-		if gc.Owner == auth.UserName {
-			delete(gameCharacterData, args.ID)
-			res.Count = int32(before - len(gameCharacterData))
-		}
+	// Only the owner may delete a character. Deleted characters
+	// are moved to the trash and can be restored by the owner.
+	gc, err := trashCharacter(localID(characterKind, args.ID), auth.UserName, args.Version)
+	if err != nil {
+		return nil, err
+	}
+	if gc != nil {
+		res.Count = 1
+		forgetCharacter(ctx, gc.ID)
+		characterEvents.publish(topicCharacterRemoved, gc)
 	}
 
-	return &resultResolver{&res}
+	return &resultResolver{&res}, nil
 }
@@ -0,0 +1,308 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+var gamesBucket = []byte("Games")
+
+// game corresponds to the GQL type Game.
+type game struct {
+	ID          graphql.ID
+	Title       string
+	Developer   string
+	Publisher   string
+	Platforms   []string
+	ReleaseYear int32
+	Genre       string
+}
+
+// getGame loads a game from the database. Returns nil if it doesn't exist.
+func getGame(id graphql.ID) *game {
+	if id == "" {
+		return nil
+	}
+
+	var g *game
+	db.View(func(tx *bolt.Tx) error {
+		g = readGame(tx, id)
+		return nil
+	})
+	return g
+}
+
+func readGame(tx *bolt.Tx, id graphql.ID) *game {
+	entry := tx.Bucket(gamesBucket).Get([]byte(id))
+	if entry == nil {
+		return nil
+	}
+	var g game
+	if err := json.Unmarshal(entry, &g); err != nil {
+		fmt.Printf("Unable to read game %s: %s\n", id, err)
+		return nil
+	}
+	return &g
+}
+
+// allGames loads all games from the database sorted by title
+func allGames() []*game {
+	var games []*game
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(gamesBucket).ForEach(func(k, v []byte) error {
+			var g game
+			if err := json.Unmarshal(v, &g); err != nil {
+				fmt.Printf("Unable to read game %s: %s\n", k, err)
+				return nil
+			}
+			games = append(games, &g)
+			return nil
+		})
+	})
+
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].Title < games[j].Title
+	})
+	return games
+}
+
+func putGame(tx *bolt.Tx, g *game) error {
+	gJSON, err := json.Marshal(g)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal game to JSON")
+	}
+	return tx.Bucket(gamesBucket).Put([]byte(g.ID), gJSON)
+}
+
+// findGameByTitle searches a game by its title, ignoring case
+func findGameByTitle(tx *bolt.Tx, title string) *game {
+	var found *game
+	tx.Bucket(gamesBucket).ForEach(func(k, v []byte) error {
+		var g game
+		if json.Unmarshal(v, &g) == nil && strings.EqualFold(g.Title, title) {
+			found = &g
+		}
+		return nil
+	})
+	return found
+}
+
+// cachedGames returns a function which reads games in the transaction.
+// Every game is only read once, characters often share their games.
+func cachedGames(tx *bolt.Tx) func(id graphql.ID) *game {
+	games := make(map[graphql.ID]*game)
+	return func(id graphql.ID) *game {
+		g, ok := games[id]
+		if !ok {
+			g = readGame(tx, id)
+			games[id] = g
+		}
+		return g
+	}
+}
+
+// gameForDebut returns the game with the given title.
+// The game is created if it doesn't exist yet.
+func gameForDebut(tx *bolt.Tx, title string, releaseYear int32) (*game, error) {
+	title = strings.TrimSpace(title)
+	if g := findGameByTitle(tx, title); g != nil {
+		return g, nil
+	}
+
+	g := &game{
+		ID:          graphql.ID(xid.New().String()),
+		Title:       title,
+		ReleaseYear: releaseYear,
+	}
+	if err := putGame(tx, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// gameIDForInput determines the game of a new character. Either an existing
+// game is referenced by ID or the game is looked up or created by its title.
+func gameIDForInput(tx *bolt.Tx, input *gameCharacterInput) (graphql.ID, error) {
+	if input.GameID != nil {
+		g := readGame(tx, localID(gameKind, *input.GameID))
+		if g == nil {
+			return "", errors.Errorf("Game %s does not exist", *input.GameID)
+		}
+		return g.ID, nil
+	}
+
+	if input.DebutGame == nil || strings.TrimSpace(*input.DebutGame) == "" {
+		return "", errors.New("Either gameId or debutGame is required")
+	}
+
+	var releaseYear int32
+	if input.ReleaseYear != nil {
+		releaseYear = *input.ReleaseYear
+	}
+
+	g, err := gameForDebut(tx, *input.DebutGame, releaseYear)
+	if err != nil {
+		return "", err
+	}
+	return g.ID, nil
+}
+
+// migrateDebutGames converts the free text debut game and release year of
+// all characters into Game records. Characters which already reference a
+// game are left untouched.
+func migrateDebutGames(tx *bolt.Tx) error {
+	// The bucket must not be changed while iterating over it
+	var pending []*gameCharacter
+	forEachCharacter(tx, func(gc *gameCharacter) error {
+		if gc.GameID == "" && gc.DebutGame != "" {
+			pending = append(pending, gc)
+		}
+		return nil
+	})
+
+	for _, gc := range pending {
+		g, err := gameForDebut(tx, gc.DebutGame, gc.ReleaseYear)
+		if err != nil {
+			return errors.Wrapf(err, "migrate debut game of %s", gc.ID)
+		}
+
+		gc.GameID = g.ID
+		gc.DebutGame = ""
+		gc.ReleaseYear = 0
+		if err := putCharacter(tx, gc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type gameResolver struct {
+	game *game
+}
+
+func (gr *gameResolver) ID() graphql.ID {
+	return globalID(gameKind, gr.game.ID)
+}
+
+func (gr *gameResolver) Title() string {
+	return gr.game.Title
+}
+
+func (gr *gameResolver) Developer() string {
+	return gr.game.Developer
+}
+
+func (gr *gameResolver) Publisher() string {
+	return gr.game.Publisher
+}
+
+func (gr *gameResolver) Platforms() []string {
+	return gr.game.Platforms
+}
+
+func (gr *gameResolver) ReleaseYear() int32 {
+	return gr.game.ReleaseYear
+}
+
+func (gr *gameResolver) Genre() string {
+	return gr.game.Genre
+}
+
+// Characters gets all characters visible to the user that appeared in this game
+func (gr *gameResolver) Characters(ctx context.Context) *[]*gameCharacterResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	var gameChars []*gameCharacterResolver
+	db.View(func(tx *bolt.Tx) error {
+		for _, id := range characterIDsOfGame(tx, gr.game.ID) {
+			if gc := activeCharacter(tx, id); gc != nil && gc.visibleTo(auth) {
+				gameChars = append(gameChars, &gameCharacterResolver{gc})
+			}
+		}
+		return nil
+	})
+
+	return &gameChars
+}
+
+// Games gets all games in the database
+func (r *Resolver) Games() *[]*gameResolver {
+	var games []*gameResolver
+	for _, g := range allGames() {
+		games = append(games, &gameResolver{g})
+	}
+	return &games
+}
+
+// Game gets one game by its ID
+func (r *Resolver) Game(args struct {
+	ID graphql.ID
+}) *gameResolver {
+	if g := getGame(localID(gameKind, args.ID)); g != nil {
+		return &gameResolver{g}
+	}
+	return nil
+}
+
+type gameInput struct {
+	Title       string
+	Developer   *string
+	Publisher   *string
+	Platforms   *[]string
+	ReleaseYear int32
+	Genre       *string
+}
+
+// AddGame adds a new game to the database
+func (r *Resolver) AddGame(ctx context.Context, args *struct {
+	Game *gameInput
+}) (*gameResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !auth.Authenticated {
+		return nil, errors.New("Only logged in users can add games")
+	}
+
+	g := &game{
+		ID:          graphql.ID(xid.New().String()),
+		Title:       strings.TrimSpace(args.Game.Title),
+		ReleaseYear: args.Game.ReleaseYear,
+	}
+	if g.Title == "" {
+		return nil, errors.New("The title of a game is required")
+	}
+	if args.Game.Developer != nil {
+		g.Developer = *args.Game.Developer
+	}
+	if args.Game.Publisher != nil {
+		g.Publisher = *args.Game.Publisher
+	}
+	if args.Game.Platforms != nil {
+		g.Platforms = *args.Game.Platforms
+	}
+	if args.Game.Genre != nil {
+		g.Genre = *args.Game.Genre
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if findGameByTitle(tx, g.Title) != nil {
+			return errors.Errorf("Game %s already exists", g.Title)
+		}
+		return putGame(tx, g)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gameResolver{g}, nil
+}
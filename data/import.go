@@ -0,0 +1,374 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// The formats of an import. JSON is an array of objects in the shape of
+// GameCharacterInput, CSV has a header with the columns in csvColumns.
+const (
+	ImportJSON = "JSON"
+	ImportCSV  = "CSV"
+)
+
+// maxImportRows limits the size of an import through the API
+const maxImportRows = 1000
+
+// csvColumns are the columns of a CSV import. Lists are separated by semicolons.
+var csvColumns = []string{
+	"name", "gameId", "debutGame", "releaseYear", "img", "desc", "wiki", "public", "owner",
+	"kind", "classes", "faction", "bossHealth", "bossPhases", "bossWeaknesses",
+}
+
+// errRollback discards the changes of a dry run or an import with errors
+var errRollback = errors.New("rollback")
+
+// ImportError describes why a row can't be imported. Rows are counted
+// from 1, the header of a CSV file isn't counted.
+type ImportError struct {
+	Row     int
+	Message string
+}
+
+// ImportResult is the outcome of an import. If any row is invalid, nothing is imported.
+type ImportResult struct {
+	Valid      int
+	Imported   int
+	DryRun     bool
+	Errors     []ImportError
+	characters []*gameCharacter
+}
+
+// importRow is a parsed row, err is set if the row couldn't be parsed
+type importRow struct {
+	input *gameCharacterInput
+	err   error
+}
+
+// parseImport reads the rows of an import. It stops with an error once
+// there are more than maxRows rows, 0 disables the limit.
+func parseImport(r io.Reader, format string, maxRows int) ([]importRow, error) {
+	switch format {
+	case ImportJSON:
+		return parseJSONImport(r, maxRows)
+	case ImportCSV:
+		return parseCSVImport(r, maxRows)
+	}
+	return nil, errors.Errorf("Unknown import format %s", format)
+}
+
+func tooManyRows(maxRows int) error {
+	return errors.Errorf("At most %d characters can be imported at once", maxRows)
+}
+
+// parseJSONImport decodes the array entry by entry, so the limit is checked
+// before a huge array is read
+func parseJSONImport(r io.Reader, maxRows int) ([]importRow, error) {
+	notAnArray := func(err error) error {
+		return errors.Wrap(err, "The import must be a JSON array of characters")
+	}
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil {
+		return nil, notAnArray(err)
+	} else if t != json.Delim('[') {
+		return nil, notAnArray(errors.Errorf("unexpected %v", t))
+	}
+
+	var rows []importRow
+	for dec.More() {
+		if maxRows > 0 && len(rows) == maxRows {
+			return nil, tooManyRows(maxRows)
+		}
+		var entry json.RawMessage
+		if err := dec.Decode(&entry); err != nil {
+			return nil, notAnArray(err)
+		}
+
+		var input gameCharacterInput
+		d := json.NewDecoder(bytes.NewReader(entry))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&input); err != nil {
+			rows = append(rows, importRow{err: err})
+			continue
+		}
+		rows = append(rows, importRow{input: &input})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, notAnArray(err)
+	}
+	return rows, nil
+}
+
+func parseCSVImport(r io.Reader, maxRows int) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read the CSV header")
+	}
+
+	known := make(map[string]bool)
+	for _, c := range csvColumns {
+		known[c] = true
+	}
+	for _, c := range header {
+		if !known[strings.TrimSpace(c)] {
+			return nil, errors.Errorf("Unknown CSV column %q, the columns are %s", c, strings.Join(csvColumns, ", "))
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if maxRows > 0 && len(rows) == maxRows {
+			return nil, tooManyRows(maxRows)
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return nil, err
+			}
+			rows = append(rows, importRow{err: err})
+			continue
+		}
+		if len(record) != len(header) {
+			rows = append(rows, importRow{err: errors.Errorf("Expected %d columns, got %d", len(header), len(record))})
+			continue
+		}
+
+		values := make(map[string]string)
+		for i, c := range header {
+			values[strings.TrimSpace(c)] = strings.TrimSpace(record[i])
+		}
+		input, err := csvInput(values)
+		rows = append(rows, importRow{input, err})
+	}
+}
+
+// csvInput converts the values of a CSV row to an input
+func csvInput(values map[string]string) (*gameCharacterInput, error) {
+	optional := func(column string) *string {
+		if v := values[column]; v != "" {
+			return &v
+		}
+		return nil
+	}
+	list := func(column string) *[]string {
+		if values[column] == "" {
+			return nil
+		}
+		var items []string
+		for _, item := range strings.Split(values[column], ";") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return &items
+	}
+	number := func(column string) (*int32, error) {
+		if values[column] == "" {
+			return nil, nil
+		}
+		n, err := strconv.ParseInt(values[column], 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("%s must be a number", column)
+		}
+		n32 := int32(n)
+		return &n32, nil
+	}
+
+	input := &gameCharacterInput{
+		Name:      values["name"],
+		DebutGame: optional("debutGame"),
+		Img:       values["img"],
+		Desc:      values["desc"],
+		Wiki:      values["wiki"],
+		Owner:     values["owner"],
+		Kind:      optional("kind"),
+		Classes:   list("classes"),
+		Faction:   optional("faction"),
+	}
+	if id := optional("gameId"); id != nil {
+		gameID := graphql.ID(*id)
+		input.GameID = &gameID
+	}
+
+	var err error
+	if input.ReleaseYear, err = number("releaseYear"); err != nil {
+		return nil, err
+	}
+	if values["public"] != "" {
+		if input.Public, err = strconv.ParseBool(values["public"]); err != nil {
+			return nil, errors.New("public must be true or false")
+		}
+	}
+
+	if values["bossHealth"] != "" || values["bossPhases"] != "" || values["bossWeaknesses"] != "" {
+		stats := &bossStatsInput{Phases: 1, Weaknesses: list("bossWeaknesses")}
+		if stats.Health, err = number("bossHealth"); err != nil {
+			return nil, err
+		}
+		phases, err := number("bossPhases")
+		if err != nil {
+			return nil, err
+		}
+		if phases != nil {
+			stats.Phases = *phases
+		}
+		input.BossStats = stats
+	}
+	return input, nil
+}
+
+// importCharacters stores all rows in one transaction. Rows without an owner
+// belong to owner. Unless anyOwner is set, all rows must belong to owner.
+func importCharacters(rows []importRow, owner string, anyOwner bool, dryRun bool) (*ImportResult, error) {
+	res := &ImportResult{DryRun: dryRun}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for i, row := range rows {
+			fail := func(err error) {
+				res.Errors = append(res.Errors, ImportError{Row: i + 1, Message: err.Error()})
+			}
+			if row.err != nil {
+				fail(row.err)
+				continue
+			}
+
+			input := row.input
+			if input.Owner == "" {
+				input.Owner = owner
+			}
+			if input.Owner == "" {
+				fail(errors.New("The owner is required"))
+				continue
+			}
+			if !anyOwner && input.Owner != owner {
+				fail(errors.New("Characters can only be imported for the logged in user"))
+				continue
+			}
+
+			gc, err := newCharacter(tx, input)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if err := putCharacter(tx, gc); err != nil {
+				return err
+			}
+			res.characters = append(res.characters, gc)
+		}
+
+		res.Valid = len(res.characters)
+		if dryRun || len(res.Errors) > 0 {
+			return errRollback
+		}
+		res.Imported = len(res.characters)
+		return nil
+	})
+	if err != nil && err != errRollback {
+		return nil, err
+	}
+	if res.Imported == 0 {
+		res.characters = nil
+	}
+
+	for _, gc := range res.characters {
+		characterEvents.publish(topicCharacterAdded, gc)
+	}
+	return res, nil
+}
+
+// ImportCharacters imports characters from JSON or CSV. Rows without an
+// owner belong to the given owner. If any row is invalid or dryRun is set,
+// nothing is imported.
+func ImportCharacters(r io.Reader, format string, owner string, dryRun bool) (*ImportResult, error) {
+	rows, err := parseImport(r, format, 0)
+	if err != nil {
+		return nil, err
+	}
+	return importCharacters(rows, owner, true, dryRun)
+}
+
+// ImportCharacters imports characters for the logged in user
+func (r *Resolver) ImportCharacters(ctx context.Context, args *struct {
+	Format string
+	Data   string
+	DryRun bool
+}) (*importResultResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !auth.Authenticated {
+		return nil, errors.New("Only logged in users can import characters")
+	}
+
+	rows, err := parseImport(strings.NewReader(args.Data), args.Format, maxImportRows)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := importCharacters(rows, auth.UserName, false, args.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	if res.Imported > 0 {
+		fmt.Printf("User %s imported %d characters.\n", auth.UserName, res.Imported)
+	}
+	return &importResultResolver{res}, nil
+}
+
+type importResultResolver struct {
+	result *ImportResult
+}
+
+func (irr *importResultResolver) Valid() int32 {
+	return int32(irr.result.Valid)
+}
+
+func (irr *importResultResolver) Imported() int32 {
+	return int32(irr.result.Imported)
+}
+
+func (irr *importResultResolver) DryRun() bool {
+	return irr.result.DryRun
+}
+
+func (irr *importResultResolver) Errors() []*importErrorResolver {
+	errs := []*importErrorResolver{}
+	for i := range irr.result.Errors {
+		errs = append(errs, &importErrorResolver{&irr.result.Errors[i]})
+	}
+	return errs
+}
+
+func (irr *importResultResolver) Characters() []*gameCharacterResolver {
+	gameChars := []*gameCharacterResolver{}
+	for _, gc := range irr.result.characters {
+		gameChars = append(gameChars, &gameCharacterResolver{gc})
+	}
+	return gameChars
+}
+
+type importErrorResolver struct {
+	err *ImportError
+}
+
+func (ier *importErrorResolver) Row() int32 {
+	return int32(ier.err.Row)
+}
+
+func (ier *importErrorResolver) Message() string {
+	return ier.err.Message
+}
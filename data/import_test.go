@@ -0,0 +1,27 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseImportStopsAtMaxRows(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{ImportJSON, `[{"name": "A"}, {"name": "B"}, {"name": "C"}]`},
+		{ImportCSV, "name\nA\nB\nC\n"},
+	}
+	for _, test := range tests {
+		if _, err := parseImport(strings.NewReader(test.data), test.format, 2); err == nil {
+			t.Errorf("%s: 3 rows were accepted with a limit of 2", test.format)
+		}
+		rows, err := parseImport(strings.NewReader(test.data), test.format, 3)
+		if err != nil {
+			t.Errorf("%s: %s", test.format, err)
+		} else if len(rows) != 3 {
+			t.Errorf("%s: got %d rows, want 3", test.format, len(rows))
+		}
+	}
+}
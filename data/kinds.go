@@ -0,0 +1,177 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The kinds of characters. Each kind is a concrete GQL type of the GameCharacter interface.
+const (
+	kindPlayable    = "PLAYABLE"
+	kindNonPlayable = "NON_PLAYABLE"
+	kindBoss        = "BOSS"
+)
+
+// bossStats describes the fight against a boss
+type bossStats struct {
+	// Health is nil if it is unknown
+	Health     *int32
+	Phases     int32
+	Weaknesses []string
+}
+
+// kind returns the kind of the character. Characters created
+// before kinds were introduced are non-playable characters.
+func (gc *gameCharacter) kind() string {
+	if gc.Kind == "" {
+		return kindNonPlayable
+	}
+	return gc.Kind
+}
+
+type bossStatsInput struct {
+	Health     *int32
+	Phases     int32
+	Weaknesses *[]string
+}
+
+// applyKind copies the kind and the fields which belong to it from the input.
+// Fields of other kinds are rejected, so they don't get lost silently.
+func (gc *gameCharacter) applyKind(input *gameCharacterInput) error {
+	gc.Kind = kindNonPlayable
+	if input.Kind != nil {
+		gc.Kind = *input.Kind
+	}
+	// The schema only allows known kinds, but imports aren't checked by it
+	if gc.Kind != kindPlayable && gc.Kind != kindNonPlayable && gc.Kind != kindBoss {
+		return errors.Errorf("Unknown kind %s", gc.Kind)
+	}
+
+	if input.Classes != nil && gc.Kind != kindPlayable {
+		return errors.New("Only playable characters have classes")
+	}
+	if input.Faction != nil && gc.Kind == kindPlayable {
+		return errors.New("Only non-playable characters and bosses have a faction")
+	}
+	if input.BossStats != nil && gc.Kind != kindBoss {
+		return errors.New("Only bosses have boss stats")
+	}
+
+	gc.Classes = nil
+	if input.Classes != nil {
+		for _, c := range *input.Classes {
+			if c = strings.TrimSpace(c); c != "" {
+				gc.Classes = append(gc.Classes, c)
+			}
+		}
+	}
+
+	gc.Faction = ""
+	if input.Faction != nil {
+		gc.Faction = strings.TrimSpace(*input.Faction)
+	}
+
+	gc.Boss = nil
+	if gc.Kind == kindBoss {
+		gc.Boss = &bossStats{Phases: 1}
+		if input.BossStats != nil {
+			if input.BossStats.Phases < 1 {
+				return errors.New("A boss fight has at least one phase")
+			}
+			gc.Boss.Health = input.BossStats.Health
+			gc.Boss.Phases = input.BossStats.Phases
+			if input.BossStats.Weaknesses != nil {
+				gc.Boss.Weaknesses = *input.BossStats.Weaknesses
+			}
+		}
+	}
+	return nil
+}
+
+// ToPlayableCharacter resolves the concrete type of the GameCharacter interface
+func (gcr *gameCharacterResolver) ToPlayableCharacter() (*playableCharacterResolver, bool) {
+	if gcr.gameCharacter.kind() == kindPlayable {
+		return &playableCharacterResolver{gcr}, true
+	}
+	return nil, false
+}
+
+// ToNonPlayableCharacter resolves the concrete type of the GameCharacter interface
+func (gcr *gameCharacterResolver) ToNonPlayableCharacter() (*nonPlayableCharacterResolver, bool) {
+	if gcr.gameCharacter.kind() == kindNonPlayable {
+		return &nonPlayableCharacterResolver{gcr}, true
+	}
+	return nil, false
+}
+
+// ToBoss resolves the concrete type of the GameCharacter interface
+func (gcr *gameCharacterResolver) ToBoss() (*bossResolver, bool) {
+	if gcr.gameCharacter.kind() == kindBoss {
+		return &bossResolver{gcr}, true
+	}
+	return nil, false
+}
+
+func (gcr *gameCharacterResolver) Kind() string {
+	return gcr.gameCharacter.kind()
+}
+
+type playableCharacterResolver struct {
+	*gameCharacterResolver
+}
+
+func (pcr *playableCharacterResolver) Classes() []string {
+	if pcr.gameCharacter.Classes == nil {
+		return []string{}
+	}
+	return pcr.gameCharacter.Classes
+}
+
+type nonPlayableCharacterResolver struct {
+	*gameCharacterResolver
+}
+
+func (npcr *nonPlayableCharacterResolver) Faction() *string {
+	if npcr.gameCharacter.Faction == "" {
+		return nil
+	}
+	return &npcr.gameCharacter.Faction
+}
+
+type bossResolver struct {
+	*gameCharacterResolver
+}
+
+func (br *bossResolver) Faction() *string {
+	if br.gameCharacter.Faction == "" {
+		return nil
+	}
+	return &br.gameCharacter.Faction
+}
+
+func (br *bossResolver) Stats() *bossStatsResolver {
+	if br.gameCharacter.Boss == nil {
+		return &bossStatsResolver{&bossStats{Phases: 1}}
+	}
+	return &bossStatsResolver{br.gameCharacter.Boss}
+}
+
+type bossStatsResolver struct {
+	stats *bossStats
+}
+
+func (bsr *bossStatsResolver) Health() *int32 {
+	return bsr.stats.Health
+}
+
+func (bsr *bossStatsResolver) Phases() int32 {
+	return bsr.stats.Phases
+}
+
+func (bsr *bossStatsResolver) Weaknesses() []string {
+	if bsr.stats.Weaknesses == nil {
+		return []string{}
+	}
+	return bsr.stats.Weaknesses
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/linkcheck"
+	"github.com/fusion44/gamechars-server/utils"
+	"github.com/pkg/errors"
+)
+
+// linkStatusBucket maps a URL to the JSON encoded linkcheck.Result of its last check
+var linkStatusBucket = []byte("LinkStatus")
+
+// The fields of a character which contain links, see the GQL enum LinkField
+const (
+	linkFieldWiki = "WIKI"
+	linkFieldImg  = "IMG"
+)
+
+// checkableURL checks whether a link points to another server. Images
+// stored next to the client are relative and can't be checked.
+func checkableURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// readLinkStatus returns the result of the last check of a URL, nil if it wasn't checked yet
+func readLinkStatus(tx *bolt.Tx, link string) *linkcheck.Result {
+	v := tx.Bucket(linkStatusBucket).Get([]byte(link))
+	if v == nil {
+		return nil
+	}
+
+	var result linkcheck.Result
+	if err := json.Unmarshal(v, &result); err != nil {
+		fmt.Println(err.Error())
+		return nil
+	}
+	return &result
+}
+
+// getLinkStatus returns the result of the last check of a URL in its own transaction
+func getLinkStatus(link string) *linkcheck.Result {
+	var result *linkcheck.Result
+	db.View(func(tx *bolt.Tx) error {
+		result = readLinkStatus(tx, link)
+		return nil
+	})
+	return result
+}
+
+// CheckLinks checks the wiki and image links of all active characters
+// and stores the results. Results of links no longer used are removed.
+// Returns the number of checked and broken links.
+func CheckLinks(ctx context.Context, checker *linkcheck.Checker) (int, int, error) {
+	var links []string
+	used := make(map[string]bool)
+	err := db.View(func(tx *bolt.Tx) error {
+		return forEachCharacter(tx, func(gc *gameCharacter) error {
+			if !gc.DeletedAt.IsZero() {
+				return nil
+			}
+			for _, link := range []string{gc.Wiki, gc.Img} {
+				if checkableURL(link) && !used[link] {
+					used[link] = true
+					links = append(links, link)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The check takes a while, so it must not block the writers
+	results := checker.Check(ctx, links)
+
+	broken := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(linkStatusBucket)
+		var stale [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if !used[string(k)] {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for i := range results {
+			if !results[i].OK() {
+				broken++
+			}
+			v, err := json.Marshal(&results[i])
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(results[i].URL), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "Unable to store the link status")
+	}
+	return len(results), broken, nil
+}
+
+// StartLinkChecker starts a background job which checks the links
+// of all characters right away and then every interval.
+func StartLinkChecker(checker *linkcheck.Checker, interval time.Duration) {
+	run := func() {
+		checked, broken, err := CheckLinks(context.Background(), checker)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		fmt.Printf("Checked %d links, %d are broken.\n", checked, broken)
+	}
+
+	go func() {
+		run()
+		for range time.Tick(interval) {
+			run()
+		}
+	}()
+}
+
+func (gcr *gameCharacterResolver) LinkStatus() *linkStatusResolver {
+	return &linkStatusResolver{gcr.gameCharacter}
+}
+
+type linkStatusResolver struct {
+	gameCharacter *gameCharacter
+}
+
+func (lsr *linkStatusResolver) Wiki(ctx context.Context) *linkCheckResolver {
+	return linkCheck(ctx, lsr.gameCharacter.Wiki)
+}
+
+func (lsr *linkStatusResolver) Img(ctx context.Context) *linkCheckResolver {
+	return linkCheck(ctx, lsr.gameCharacter.Img)
+}
+
+// linkCheck returns the last check of a link, nil if it wasn't checked
+func linkCheck(ctx context.Context, link string) *linkCheckResolver {
+	if !checkableURL(link) {
+		return nil
+	}
+	if result := loadLinkStatus(ctx, link); result != nil {
+		return &linkCheckResolver{result}
+	}
+	return nil
+}
+
+type linkCheckResolver struct {
+	result *linkcheck.Result
+}
+
+func (lcr *linkCheckResolver) URL() string {
+	return lcr.result.URL
+}
+
+func (lcr *linkCheckResolver) OK() bool {
+	return lcr.result.OK()
+}
+
+func (lcr *linkCheckResolver) Status() *int32 {
+	if lcr.result.StatusCode == 0 {
+		return nil
+	}
+	status := int32(lcr.result.StatusCode)
+	return &status
+}
+
+// Error is only shown to admins, it could reveal details of the network
+// of the server
+func (lcr *linkCheckResolver) Error(ctx context.Context) *string {
+	if lcr.result.Error == "" {
+		return nil
+	}
+	if auth, err := utils.GetContextAuthData(ctx); err != nil || !IsAdmin(auth) {
+		return nil
+	}
+	return &lcr.result.Error
+}
+
+func (lcr *linkCheckResolver) CheckedAt() string {
+	return lcr.result.CheckedAt.Format(time.RFC3339)
+}
+
+// brokenLink is a link of a character which failed its last check
+type brokenLink struct {
+	gameCharacter *gameCharacter
+	field         string
+	result        *linkcheck.Result
+}
+
+// BrokenLinks lists the links of all active characters which failed their last check
+func (r *Resolver) BrokenLinks(ctx context.Context) ([]*brokenLinkResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !IsAdmin(auth) {
+		return nil, errors.New("Only admins can list broken links")
+	}
+
+	links := []*brokenLinkResolver{}
+	err = db.View(func(tx *bolt.Tx) error {
+		return forEachCharacter(tx, func(gc *gameCharacter) error {
+			if !gc.DeletedAt.IsZero() {
+				return nil
+			}
+			fields := []string{linkFieldWiki, linkFieldImg}
+			for i, link := range []string{gc.Wiki, gc.Img} {
+				if !checkableURL(link) {
+					continue
+				}
+				if result := readLinkStatus(tx, link); result != nil && !result.OK() {
+					links = append(links, &brokenLinkResolver{&brokenLink{gc, fields[i], result}})
+				}
+			}
+			return nil
+		})
+	})
+	return links, err
+}
+
+type brokenLinkResolver struct {
+	link *brokenLink
+}
+
+func (blr *brokenLinkResolver) Character() *gameCharacterResolver {
+	return &gameCharacterResolver{blr.link.gameCharacter}
+}
+
+func (blr *brokenLinkResolver) Field() string {
+	return blr.link.field
+}
+
+func (blr *brokenLinkResolver) Check() *linkCheckResolver {
+	return &linkCheckResolver{blr.link.result}
+}
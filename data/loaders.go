@@ -0,0 +1,180 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/linkcheck"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// loaderWait is how long a loader collects keys before it reads them
+// all in one transaction. graphql-go resolves the fields of list items
+// concurrently, so the fields of all items end up in the same batch.
+const loaderWait = time.Millisecond
+
+type contextKey string
+
+var loadersContextKey = contextKey("loaders")
+
+// loaderResult is closed once the value of a key was read
+type loaderResult struct {
+	done  chan struct{}
+	value interface{}
+}
+
+// loader batches lookups by key and caches the results
+type loader struct {
+	read func(tx *bolt.Tx, id graphql.ID) interface{}
+
+	mutex   sync.Mutex
+	cache   map[graphql.ID]*loaderResult
+	pending map[graphql.ID]*loaderResult
+}
+
+func newLoader(read func(tx *bolt.Tx, id graphql.ID) interface{}) *loader {
+	return &loader{read: read, cache: make(map[graphql.ID]*loaderResult)}
+}
+
+// load returns the value of a key. Keys requested within loaderWait of
+// each other are read in the same transaction.
+func (l *loader) load(id graphql.ID) interface{} {
+	l.mutex.Lock()
+	result, ok := l.cache[id]
+	if !ok {
+		result = &loaderResult{done: make(chan struct{})}
+		l.cache[id] = result
+		if l.pending == nil {
+			l.pending = make(map[graphql.ID]*loaderResult)
+			time.AfterFunc(loaderWait, l.dispatch)
+		}
+		l.pending[id] = result
+	}
+	l.mutex.Unlock()
+
+	<-result.done
+	return result.value
+}
+
+func (l *loader) dispatch() {
+	l.mutex.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mutex.Unlock()
+
+	db.View(func(tx *bolt.Tx) error {
+		for id, result := range pending {
+			result.value = l.read(tx, id)
+		}
+		return nil
+	})
+	for _, result := range pending {
+		close(result.done)
+	}
+}
+
+// forget removes a key from the cache after it was changed
+func (l *loader) forget(id graphql.ID) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.cache, id)
+}
+
+// loaders holds the loaders of one request
+type loaders struct {
+	characters     *loader
+	games          *loader
+	tags           *loader
+	favoriteCounts *loader
+	linkStatus     *loader
+}
+
+// WithLoaders attaches new loaders to the context of a request. Everything
+// read through them is cached until the request is finished.
+func WithLoaders(ctx context.Context) context.Context {
+	l := &loaders{
+		characters: newLoader(func(tx *bolt.Tx, id graphql.ID) interface{} {
+			return activeCharacter(tx, id)
+		}),
+		games: newLoader(func(tx *bolt.Tx, id graphql.ID) interface{} {
+			return readGame(tx, id)
+		}),
+		tags: newLoader(func(tx *bolt.Tx, id graphql.ID) interface{} {
+			return readCharacterTags(tx, id)
+		}),
+		favoriteCounts: newLoader(func(tx *bolt.Tx, id graphql.ID) interface{} {
+			return getFavoriteCount(tx, id)
+		}),
+		linkStatus: newLoader(func(tx *bolt.Tx, link graphql.ID) interface{} {
+			return readLinkStatus(tx, string(link))
+		}),
+	}
+	return context.WithValue(ctx, loadersContextKey, l)
+}
+
+// WithoutLoaders removes the loaders from a context. Long running operations
+// like subscriptions must not see values cached at their start.
+func WithoutLoaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadersContextKey, (*loaders)(nil))
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	l, _ := ctx.Value(loadersContextKey).(*loaders)
+	return l
+}
+
+// loadCharacter loads a character which is not in the trash through the loaders of the request if there are any
+func loadCharacter(ctx context.Context, id graphql.ID) *gameCharacter {
+	l := loadersFrom(ctx)
+	if l == nil || id == "" {
+		return getCharacter(id)
+	}
+	return l.characters.load(id).(*gameCharacter)
+}
+
+// loadGame loads a game through the loaders of the request if there are any
+func loadGame(ctx context.Context, id graphql.ID) *game {
+	l := loadersFrom(ctx)
+	if l == nil || id == "" {
+		return getGame(id)
+	}
+	return l.games.load(id).(*game)
+}
+
+// loadCharacterTags loads the tags of a character through the loaders of the request if there are any
+func loadCharacterTags(ctx context.Context, id graphql.ID) []string {
+	l := loadersFrom(ctx)
+	if l == nil {
+		return characterTags(id)
+	}
+	return l.tags.load(id).([]string)
+}
+
+// loadFavoriteCount loads the favorite count of a character through the loaders of the request if there are any
+func loadFavoriteCount(ctx context.Context, id graphql.ID) int32 {
+	l := loadersFrom(ctx)
+	if l == nil {
+		return favoriteCount(id)
+	}
+	return int32(l.favoriteCounts.load(id).(uint64))
+}
+
+// loadLinkStatus loads the last check of a link through the loaders of the request if there are any
+func loadLinkStatus(ctx context.Context, link string) *linkcheck.Result {
+	l := loadersFrom(ctx)
+	if l == nil {
+		return getLinkStatus(link)
+	}
+	return l.linkStatus.load(graphql.ID(link)).(*linkcheck.Result)
+}
+
+// forgetCharacter removes everything cached about a character after it was changed
+func forgetCharacter(ctx context.Context, id graphql.ID) {
+	if l := loadersFrom(ctx); l != nil {
+		l.characters.forget(id)
+		l.tags.forget(id)
+		l.favoriteCounts.forget(id)
+	}
+}
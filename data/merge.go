@@ -0,0 +1,398 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// mergeRecord records a character which was merged into another one
+type mergeRecord struct {
+	ID       graphql.ID
+	Name     string
+	Owner    string
+	Source   *provenance
+	MergedAt time.Time
+	MergedBy string
+}
+
+// mergeFields fills the fields the target is missing from the source and
+// adds the games the source appeared in to the appearances of the target.
+// The source is recorded with its provenance, after the characters which
+// were merged into it before.
+func (gc *gameCharacter) mergeFields(source *gameCharacter, mergedAt time.Time, mergedBy string) {
+	if gc.GameID == "" {
+		gc.GameID = source.GameID
+	}
+	if gc.Img == "" {
+		gc.Img = source.Img
+	}
+	if gc.Desc == "" {
+		gc.Desc = source.Desc
+	}
+	if gc.Wiki == "" {
+		gc.Wiki = source.Wiki
+	}
+	if gc.Creator == "" {
+		gc.Creator = source.Creator
+	}
+	if gc.Source == nil {
+		gc.Source = source.Source
+	}
+	for _, a := range source.Appearances {
+		if !gc.appearedIn(a.GameID) {
+			gc.Appearances = append(gc.Appearances, a)
+		}
+	}
+	gc.Merged = append(gc.Merged, source.Merged...)
+	gc.Merged = append(gc.Merged, &mergeRecord{
+		ID:       source.ID,
+		Name:     source.Name,
+		Owner:    source.Owner,
+		Source:   source.Source,
+		MergedAt: mergedAt,
+		MergedBy: mergedBy,
+	})
+}
+
+// mergeTags gives the target the tags of all sources
+func mergeTags(tx *bolt.Tx, target graphql.ID, sources []graphql.ID) error {
+	tags := readCharacterTags(tx, target)
+	seen := tagSet(tags)
+	for _, id := range sources {
+		for _, tag := range readCharacterTags(tx, id) {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		if err := setCharacterTags(tx, id, nil); err != nil {
+			return err
+		}
+	}
+	return setCharacterTags(tx, target, tags)
+}
+
+// mergeFavorites replaces the sources with the target in the favorites of
+// all users. Users who liked several of them only count once.
+func mergeFavorites(tx *bolt.Tx, target graphql.ID, sources []graphql.ID) error {
+	favorites := tx.Bucket(favoritesBucket)
+	var userNames [][]byte
+	favorites.ForEach(func(userName, v []byte) error {
+		userNames = append(userNames, userName)
+		return nil
+	})
+
+	count := getFavoriteCount(tx, target)
+	for _, userName := range userNames {
+		b := favorites.Bucket(userName)
+		if b == nil {
+			continue
+		}
+		for _, id := range sources {
+			addedAt := b.Get([]byte(id))
+			if addedAt == nil {
+				continue
+			}
+			if b.Get([]byte(target)) == nil {
+				if err := b.Put([]byte(target), append([]byte{}, addedAt...)); err != nil {
+					return err
+				}
+				count++
+			}
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, id := range sources {
+		if err := putFavoriteCount(tx, id, 0); err != nil {
+			return err
+		}
+	}
+	return putFavoriteCount(tx, target, count)
+}
+
+// mergeComments moves the comments of the sources to the target
+func mergeComments(tx *bolt.Tx, target graphql.ID, sources map[graphql.ID]bool) error {
+	var moved []*comment
+	err := tx.Bucket(commentsBucket).ForEach(func(k, v []byte) error {
+		var c comment
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+		if sources[c.CharacterID] {
+			moved = append(moved, &c)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	threads := tx.Bucket(commentThreadsBucket)
+	for _, c := range moved {
+		c.CharacterID = target
+		if err := putComment(tx, c); err != nil {
+			return err
+		}
+		// Replies stay in the thread of their parent
+		if c.ParentID != "" {
+			continue
+		}
+		thread, err := threads.CreateBucketIfNotExists([]byte(target))
+		if err != nil {
+			return err
+		}
+		if err := thread.Put([]byte(c.ID), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	for id := range sources {
+		if threads.Bucket([]byte(id)) != nil {
+			if err := threads.DeleteBucket([]byte(id)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeRelationships points the relationships of the sources to the target.
+// Relationships between the merged characters and ones the target already
+// has are deleted.
+func mergeRelationships(tx *bolt.Tx, target graphql.ID, ids []graphql.ID) error {
+	sources := make(map[graphql.ID]bool)
+	for _, id := range ids {
+		sources[id] = true
+	}
+
+	var rels []*relationship
+	seen := make(map[graphql.ID]bool)
+	for _, id := range append([]graphql.ID{target}, ids...) {
+		for _, rel := range relationshipsOf(tx, id) {
+			if !seen[rel.ID] {
+				seen[rel.ID] = true
+				rels = append(rels, rel)
+			}
+		}
+	}
+
+	edge := func(rel *relationship) string {
+		return fmt.Sprintf("%s|%s|%s", rel.From, rel.To, rel.Kind)
+	}
+	existing := make(map[string]bool)
+	for _, rel := range rels {
+		if !sources[rel.From] && !sources[rel.To] {
+			existing[edge(rel)] = true
+		}
+	}
+
+	for _, rel := range rels {
+		if !sources[rel.From] && !sources[rel.To] {
+			continue
+		}
+		if sources[rel.From] {
+			rel.From = target
+		}
+		if sources[rel.To] {
+			rel.To = target
+		}
+
+		if rel.From == rel.To || existing[edge(rel)] {
+			if err := deleteRelationship(tx, readRelationship(tx, rel.ID)); err != nil {
+				return err
+			}
+			continue
+		}
+		existing[edge(rel)] = true
+		if err := putRelationship(tx, rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeCollections replaces the sources with the target in all collections.
+// The target keeps the first position any of them had.
+func mergeCollections(tx *bolt.Tx, target graphql.ID, sources map[graphql.ID]bool) error {
+	var collections []*collection
+	err := tx.Bucket(collectionsBucket).ForEach(func(k, v []byte) error {
+		var c collection
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+		collections = append(collections, &c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range collections {
+		changed := false
+		seenTarget := false
+		var ids []graphql.ID
+		for _, id := range c.CharacterIDs {
+			if sources[id] {
+				id = target
+				changed = true
+			}
+			if id == target {
+				if seenTarget {
+					continue
+				}
+				seenTarget = true
+			}
+			ids = append(ids, id)
+		}
+		if !changed {
+			continue
+		}
+		c.CharacterIDs = ids
+		if err := putCollection(tx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeCharacters merges the sources into the target and deletes them.
+// Everything referring to the sources refers to the target afterwards.
+func mergeCharacters(tx *bolt.Tx, target *gameCharacter, sources []*gameCharacter, mergedBy string) error {
+	var ids []graphql.ID
+	isSource := make(map[graphql.ID]bool)
+	mergedAt := time.Now()
+	for _, s := range sources {
+		target.mergeFields(s, mergedAt, mergedBy)
+		ids = append(ids, s.ID)
+		isSource[s.ID] = true
+	}
+
+	if err := mergeTags(tx, target.ID, ids); err != nil {
+		return errors.Wrap(err, "Unable to merge the tags")
+	}
+	if err := mergeFavorites(tx, target.ID, ids); err != nil {
+		return errors.Wrap(err, "Unable to merge the favorites")
+	}
+	if err := mergeComments(tx, target.ID, isSource); err != nil {
+		return errors.Wrap(err, "Unable to merge the comments")
+	}
+	if err := mergeRelationships(tx, target.ID, ids); err != nil {
+		return errors.Wrap(err, "Unable to merge the relationships")
+	}
+	if err := mergeCollections(tx, target.ID, isSource); err != nil {
+		return errors.Wrap(err, "Unable to merge the collections")
+	}
+
+	for _, id := range ids {
+		if err := removeCharacter(tx, id); err != nil {
+			return err
+		}
+	}
+	return putCharacter(tx, target)
+}
+
+// MergeCharacters merges duplicates into the target character. The target
+// gets their tags, favorites, comments, relationships, appearances and the
+// fields it is missing. The duplicates are deleted afterwards and recorded
+// in Merged of the target.
+func (r *Resolver) MergeCharacters(ctx context.Context, args *struct {
+	TargetID  graphql.ID
+	Version   int32
+	SourceIDs []graphql.ID
+}) (*gameCharacterResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil || !IsAdmin(auth) {
+		return nil, errors.New("Only admins can merge characters")
+	}
+	if len(args.SourceIDs) == 0 {
+		return nil, errors.New("At least one character to merge is required")
+	}
+
+	var target *gameCharacter
+	var sources []*gameCharacter
+	err = db.Update(func(tx *bolt.Tx) error {
+		target = activeCharacter(tx, localID(characterKind, args.TargetID))
+		if target == nil {
+			return errors.Errorf("Character %s does not exist", args.TargetID)
+		}
+		// Increases the version, clients holding the old one get a conflict
+		if err := checkVersion(target, args.Version); err != nil {
+			return err
+		}
+
+		seen := map[graphql.ID]bool{target.ID: true}
+		for _, id := range args.SourceIDs {
+			gc := activeCharacter(tx, localID(characterKind, id))
+			if gc == nil {
+				return errors.Errorf("Character %s does not exist", id)
+			}
+			if seen[gc.ID] {
+				return errors.Errorf("Character %s is merged more than once", id)
+			}
+			seen[gc.ID] = true
+			sources = append(sources, gc)
+		}
+		return mergeCharacters(tx, target, sources, auth.UserName)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	forgetCharacter(ctx, target.ID)
+	for _, gc := range sources {
+		forgetCharacter(ctx, gc.ID)
+		characterEvents.publish(topicCharacterRemoved, gc)
+	}
+	characterEvents.publish(topicCharacterUpdated, target)
+	fmt.Printf("User %s merged %d characters into %s.\n", auth.UserName, len(sources), target.ID)
+	return &gameCharacterResolver{target}, nil
+}
+
+func (gcr *gameCharacterResolver) MergedCharacters() []*mergedCharacterResolver {
+	merged := []*mergedCharacterResolver{}
+	for _, m := range gcr.gameCharacter.Merged {
+		merged = append(merged, &mergedCharacterResolver{m})
+	}
+	return merged
+}
+
+type mergedCharacterResolver struct {
+	record *mergeRecord
+}
+
+func (mcr *mergedCharacterResolver) ID() graphql.ID {
+	return globalID(characterKind, mcr.record.ID)
+}
+
+func (mcr *mergedCharacterResolver) Name() string {
+	return mcr.record.Name
+}
+
+func (mcr *mergedCharacterResolver) Owner() string {
+	return mcr.record.Owner
+}
+
+func (mcr *mergedCharacterResolver) Provenance() *provenanceResolver {
+	if mcr.record.Source == nil {
+		return nil
+	}
+	return &provenanceResolver{mcr.record.Source}
+}
+
+func (mcr *mergedCharacterResolver) MergedAt() string {
+	return mcr.record.MergedAt.Format(time.RFC3339)
+}
+
+func (mcr *mergedCharacterResolver) MergedBy() string {
+	return mcr.record.MergedBy
+}
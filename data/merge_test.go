@@ -0,0 +1,85 @@
+package data
+
+import (
+	"testing"
+
+	bolt "github.com/coreos/bbolt"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// TestMergeCharacters merges GLaDOS into Gordon Freeman of the seeded characters
+func TestMergeCharacters(t *testing.T) {
+	openTestDB(t)
+	SetAdmins([]string{"admin"})
+	defer SetAdmins(nil)
+	r := &Resolver{}
+
+	if _, err := r.TagCharacter(requestContext("fusion44"), &struct {
+		ID      graphql.ID
+		Version int32
+		Tags    []string
+	}{"1001", 1, []string{"merged"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.FavoriteCharacter(requestContext("fan"), &struct{ ID graphql.ID }{"1001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The duplicate was imported from a wiki
+	err := db.Update(func(tx *bolt.Tx) error {
+		gc := readCharacter(tx, "1001")
+		gc.Source = &provenance{Page: "GLaDOS", URL: "https://wiki.example.com/wiki/GLaDOS", Revision: "42"}
+		return putCharacter(tx, gc)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merge := func(version int32) (*gameCharacterResolver, error) {
+		return r.MergeCharacters(requestContext("admin"), &struct {
+			TargetID  graphql.ID
+			Version   int32
+			SourceIDs []graphql.ID
+		}{"1000", version, []graphql.ID{"1001"}})
+	}
+	target, err := merge(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Version() != 2 {
+		t.Errorf("got version %d of the merged character, want 2", target.Version())
+	}
+
+	ctx := requestContext("reader")
+	if r.GameCharacter(ctx, struct{ ID graphql.ID }{"1001"}) != nil {
+		t.Error("the merged duplicate still exists")
+	}
+	if tags := target.Tags(ctx); len(tags) != 1 || tags[0] != "merged" {
+		t.Errorf("got tags %v, want [merged]", tags)
+	}
+	if favorites := r.Favorites(requestContext("fan")); len(favorites) != 1 || favorites[0].gameCharacter.ID != "1000" {
+		t.Errorf("got %d favorites, want the merged character", len(favorites))
+	}
+	merged := target.MergedCharacters()
+	if len(merged) != 1 {
+		t.Fatalf("got %d merged characters, want 1", len(merged))
+	}
+	if merged[0].ID() != globalID(characterKind, "1001") || merged[0].Name() != "GLaDOS" || merged[0].MergedBy() != "admin" {
+		t.Errorf("got merged character %s %q by %s, want GLaDOS by admin", merged[0].ID(), merged[0].Name(), merged[0].MergedBy())
+	}
+	if p := merged[0].Provenance(); p == nil || p.Revision() != "42" {
+		t.Error("the provenance of the merged character is lost")
+	}
+
+	// Clients which saw the character before the merge must not overwrite it
+	if _, err := r.TagCharacter(requestContext("fusion44"), &struct {
+		ID      graphql.ID
+		Version int32
+		Tags    []string
+	}{"1000", 1, []string{"stale"}}); err == nil {
+		t.Error("a change of the version before the merge was accepted")
+	}
+	if _, err := merge(1); err == nil {
+		t.Error("a merge with the version before the merge was accepted")
+	}
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"encoding/json"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/migrations"
+)
+
+// Migrations convert databases of older versions. They run in this order, so
+// never remove or reorder them. Append a new migration for every change to
+// the format of the stored records.
+var Migrations = []migrations.Migration{
+	{Name: "Seed characters", Up: seedCharacters},
+	{Name: "Convert debut games to games", Up: migrateDebutGames},
+	{Name: "Add versions to characters", Up: addCharacterVersions},
+	{Name: "Index characters by game", Up: indexCharactersByGame},
+	{Name: "Index relationships by character", Up: indexRelationshipsByCharacter},
+}
+
+// addCharacterVersions starts characters stored before versions existed at version 1
+func addCharacterVersions(tx *bolt.Tx) error {
+	// The bucket must not be changed while iterating over it
+	var pending []*gameCharacter
+	forEachCharacter(tx, func(gc *gameCharacter) error {
+		if gc.Version == 0 {
+			pending = append(pending, gc)
+		}
+		return nil
+	})
+
+	for _, gc := range pending {
+		gc.Version = 1
+		if err := putCharacter(tx, gc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexCharactersByGame fills the index of the games for characters stored before it existed
+func indexCharactersByGame(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(charactersByGameBucket); err != nil {
+		return err
+	}
+
+	var characters []*gameCharacter
+	forEachCharacter(tx, func(gc *gameCharacter) error {
+		characters = append(characters, gc)
+		return nil
+	})
+	for _, gc := range characters {
+		if err := indexCharacterGames(tx, nil, gc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRelationshipsByCharacter fills the index of the relationships for edges stored before it existed
+func indexRelationshipsByCharacter(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(relationshipsByCharacterBucket); err != nil {
+		return err
+	}
+
+	var rels []*relationship
+	err := tx.Bucket(relationshipsBucket).ForEach(func(k, v []byte) error {
+		var rel relationship
+		if err := json.Unmarshal(v, &rel); err != nil {
+			return err
+		}
+		rels = append(rels, &rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rel := range rels {
+		if err := indexRelationship(tx, rel, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
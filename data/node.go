@@ -0,0 +1,197 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"github.com/pkg/errors"
+)
+
+// The types of the global IDs. Characters of all kinds share one type,
+// so their IDs stay the same if the kind of a character changes.
+const (
+	characterKind  = "GameCharacter"
+	userKind       = "User"
+	gameKind       = "Game"
+	collectionKind = "Collection"
+)
+
+const maxNodes = 100
+
+var usersBucket = []byte("Users")
+
+// globalID creates an ID which is unique across all types as required by the
+// Relay object identification spec: https://relay.dev/graphql/objectidentification.htm
+func globalID(kind string, id graphql.ID) graphql.ID {
+	return relay.MarshalID(kind, id)
+}
+
+// localID decodes the global ID of an object of the given type. IDs which
+// were handed out before global IDs existed are returned unchanged, IDs
+// of other types are decoded to an empty ID, which never exists.
+func localID(kind string, id graphql.ID) graphql.ID {
+	switch relay.UnmarshalKind(id) {
+	case kind:
+		var local graphql.ID
+		if err := relay.UnmarshalSpec(id, &local); err != nil {
+			return ""
+		}
+		return local
+	case characterKind, userKind, gameKind, collectionKind:
+		return ""
+	}
+	return id
+}
+
+// getUser loads a user by ID. Users are stored by name, so all of them are searched.
+func getUser(tx *bolt.Tx, id graphql.ID) (*user, error) {
+	var found *user
+	err := tx.Bucket(usersBucket).ForEach(func(k, v []byte) error {
+		if found != nil {
+			return nil
+		}
+		var u UserDbModel
+		if err := json.Unmarshal(v, &u); err != nil {
+			return errors.Wrapf(err, "Unable to read user %s", k)
+		}
+		if u.ID == id {
+			found = &user{ID: u.ID, UserName: string(u.UserName), Email: string(u.Email)}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// nodeResolver resolves the concrete type of a Node
+type nodeResolver struct {
+	node interface {
+		ID() graphql.ID
+	}
+}
+
+func (nr *nodeResolver) ID() graphql.ID {
+	return nr.node.ID()
+}
+
+func (nr *nodeResolver) ToPlayableCharacter() (*playableCharacterResolver, bool) {
+	if r, ok := nr.node.(*gameCharacterResolver); ok {
+		return r.ToPlayableCharacter()
+	}
+	return nil, false
+}
+
+func (nr *nodeResolver) ToNonPlayableCharacter() (*nonPlayableCharacterResolver, bool) {
+	if r, ok := nr.node.(*gameCharacterResolver); ok {
+		return r.ToNonPlayableCharacter()
+	}
+	return nil, false
+}
+
+func (nr *nodeResolver) ToBoss() (*bossResolver, bool) {
+	if r, ok := nr.node.(*gameCharacterResolver); ok {
+		return r.ToBoss()
+	}
+	return nil, false
+}
+
+func (nr *nodeResolver) ToUser() (*userResolver, bool) {
+	r, ok := nr.node.(*userResolver)
+	return r, ok
+}
+
+func (nr *nodeResolver) ToGame() (*gameResolver, bool) {
+	r, ok := nr.node.(*gameResolver)
+	return r, ok
+}
+
+func (nr *nodeResolver) ToCollection() (*collectionResolver, bool) {
+	r, ok := nr.node.(*collectionResolver)
+	return r, ok
+}
+
+// resolveNode finds the object of a global ID if the user may see it.
+// Users can only see themselves as the type contains the email address.
+func resolveNode(auth utils.AuthData, id graphql.ID) (*nodeResolver, error) {
+	kind := relay.UnmarshalKind(id)
+	local := localID(kind, id)
+
+	switch kind {
+	case characterKind:
+		if gc := getCharacter(local); gc != nil && gc.visibleTo(auth) {
+			return &nodeResolver{&gameCharacterResolver{gc}}, nil
+		}
+	case gameKind:
+		if g := getGame(local); g != nil {
+			return &nodeResolver{&gameResolver{g}}, nil
+		}
+	case collectionKind:
+		var c *collection
+		err := db.View(func(tx *bolt.Tx) error {
+			var err error
+			c, err = getCollection(tx, local)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if c != nil && c.visibleTo(auth) {
+			return &nodeResolver{&collectionResolver{c}}, nil
+		}
+	case userKind:
+		if !auth.Authenticated {
+			return nil, nil
+		}
+		var u *user
+		err := db.View(func(tx *bolt.Tx) error {
+			var err error
+			u, err = getUser(tx, local)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if u != nil && u.UserName == auth.UserName {
+			return &nodeResolver{&userResolver{u}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Node gets any object by its global ID
+func (r *Resolver) Node(ctx context.Context, args struct {
+	ID graphql.ID
+}) (*nodeResolver, error) {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	return resolveNode(auth, args.ID)
+}
+
+// Nodes gets several objects by their global IDs in the same order.
+// Objects which don't exist or aren't visible are null.
+func (r *Resolver) Nodes(ctx context.Context, args struct {
+	IDs []graphql.ID
+}) ([]*nodeResolver, error) {
+	if len(args.IDs) > maxNodes {
+		return nil, errors.Errorf("At most %d nodes can be requested at once", maxNodes)
+	}
+
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	nodes := make([]*nodeResolver, len(args.IDs))
+	for i, id := range args.IDs {
+		if nodes[i], err = resolveNode(auth, id); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
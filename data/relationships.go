@@ -0,0 +1,320 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+var relationshipsBucket = []byte("Relationships")
+
+// relationshipsByCharacterBucket is an index which holds one nested bucket
+// per character with the IDs of all edges from or to it as keys
+var relationshipsByCharacterBucket = []byte("RelationshipsByCharacter")
+
+// maxRelationshipDepth limits how far relationships are traversed in one query
+const maxRelationshipDepth = 3
+
+// relationship is an edge between two characters
+type relationship struct {
+	ID   graphql.ID
+	From graphql.ID
+	To   graphql.ID
+	Kind string
+	// Directed relationships only apply from From to To,
+	// e.g. GLaDOS is the antagonist of Chell but not vice versa
+	Directed    bool
+	Description string
+	Owner       string
+}
+
+// readRelationship reads a relationship, nil if it doesn't exist
+func readRelationship(tx *bolt.Tx, id graphql.ID) *relationship {
+	v := tx.Bucket(relationshipsBucket).Get([]byte(id))
+	if v == nil {
+		return nil
+	}
+
+	var rel relationship
+	if err := json.Unmarshal(v, &rel); err != nil {
+		fmt.Printf("Unable to read relationship %s: %s\n", id, err)
+		return nil
+	}
+	return &rel
+}
+
+// indexRelationship adds or, if remove is set, removes the entries of the edge in the index
+func indexRelationship(tx *bolt.Tx, rel *relationship, remove bool) error {
+	index := tx.Bucket(relationshipsByCharacterBucket)
+	for _, id := range []graphql.ID{rel.From, rel.To} {
+		if remove {
+			b := index.Bucket([]byte(id))
+			if b == nil {
+				continue
+			}
+			if err := b.Delete([]byte(rel.ID)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b, err := index.CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(rel.ID), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putRelationship stores an edge and keeps the index up to date
+func putRelationship(tx *bolt.Tx, rel *relationship) error {
+	relJSON, err := json.Marshal(rel)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal relationship to JSON")
+	}
+	if old := readRelationship(tx, rel.ID); old != nil {
+		if err := indexRelationship(tx, old, true); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket(relationshipsBucket).Put([]byte(rel.ID), relJSON); err != nil {
+		return err
+	}
+	return indexRelationship(tx, rel, false)
+}
+
+// deleteRelationship deletes an edge and its index entries
+func deleteRelationship(tx *bolt.Tx, rel *relationship) error {
+	if err := indexRelationship(tx, rel, true); err != nil {
+		return err
+	}
+	return tx.Bucket(relationshipsBucket).Delete([]byte(rel.ID))
+}
+
+// relationshipsOf reads all edges from or to the given character from the index
+func relationshipsOf(tx *bolt.Tx, id graphql.ID) []*relationship {
+	b := tx.Bucket(relationshipsByCharacterBucket).Bucket([]byte(id))
+	if b == nil {
+		return nil
+	}
+
+	var rels []*relationship
+	b.ForEach(func(k, v []byte) error {
+		if rel := readRelationship(tx, graphql.ID(k)); rel != nil {
+			rels = append(rels, rel)
+		}
+		return nil
+	})
+	return rels
+}
+
+// deleteRelationshipsOf deletes all edges from or to the given character
+func deleteRelationshipsOf(tx *bolt.Tx, id graphql.ID) error {
+	for _, rel := range relationshipsOf(tx, id) {
+		if err := deleteRelationship(tx, rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visibleRelationships traverses the relationship graph starting at the
+// given character up to depth edges away. Edges pointing at characters
+// the user isn't allowed to see are hidden and not followed. Only the
+// edges reachable from the start are read.
+func visibleRelationships(tx *bolt.Tx, start graphql.ID, depth int, auth utils.AuthData) []*relationship {
+	characters := make(map[graphql.ID]*gameCharacter)
+	visible := func(id graphql.ID) bool {
+		gc, ok := characters[id]
+		if !ok {
+			gc = activeCharacter(tx, id)
+			characters[id] = gc
+		}
+		return gc != nil && gc.visibleTo(auth)
+	}
+
+	var result []*relationship
+	seenEdges := make(map[graphql.ID]bool)
+	visited := map[graphql.ID]bool{start: true}
+	current := []graphql.ID{start}
+	for level := 0; level < depth && len(current) > 0; level++ {
+		var next []graphql.ID
+		for _, id := range current {
+			for _, rel := range relationshipsOf(tx, id) {
+				if seenEdges[rel.ID] {
+					continue
+				}
+
+				other := rel.To
+				if other == id {
+					other = rel.From
+				}
+				if !visible(other) {
+					continue
+				}
+
+				seenEdges[rel.ID] = true
+				result = append(result, rel)
+				if !visited[other] {
+					visited[other] = true
+					next = append(next, other)
+				}
+			}
+		}
+		current = next
+	}
+	return result
+}
+
+type relationshipResolver struct {
+	relationship *relationship
+}
+
+func (rr *relationshipResolver) ID() graphql.ID {
+	return rr.relationship.ID
+}
+
+func (rr *relationshipResolver) Kind() string {
+	return rr.relationship.Kind
+}
+
+func (rr *relationshipResolver) Directed() bool {
+	return rr.relationship.Directed
+}
+
+func (rr *relationshipResolver) Description() string {
+	return rr.relationship.Description
+}
+
+func (rr *relationshipResolver) Owner() string {
+	return rr.relationship.Owner
+}
+
+func (rr *relationshipResolver) From(ctx context.Context) *gameCharacterResolver {
+	return visibleCharacter(ctx, rr.relationship.From)
+}
+
+func (rr *relationshipResolver) To(ctx context.Context) *gameCharacterResolver {
+	return visibleCharacter(ctx, rr.relationship.To)
+}
+
+// visibleCharacter returns a resolver for the character if the user is allowed to see it
+func visibleCharacter(ctx context.Context, id graphql.ID) *gameCharacterResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	if gc := loadCharacter(ctx, id); gc != nil && gc.visibleTo(auth) {
+		return &gameCharacterResolver{gc}
+	}
+	return nil
+}
+
+// Relationships gets all relationships of the character up to depth edges away
+func (gcr *gameCharacterResolver) Relationships(ctx context.Context, args struct {
+	Depth int32
+}) ([]*relationshipResolver, error) {
+	if args.Depth < 1 || args.Depth > maxRelationshipDepth {
+		return nil, errors.Errorf("depth must be between 1 and %d", maxRelationshipDepth)
+	}
+
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	var rels []*relationshipResolver
+	db.View(func(tx *bolt.Tx) error {
+		for _, rel := range visibleRelationships(tx, gcr.gameCharacter.ID, int(args.Depth), auth) {
+			rels = append(rels, &relationshipResolver{rel})
+		}
+		return nil
+	})
+	return rels, nil
+}
+
+type relationshipInput struct {
+	From        graphql.ID
+	To          graphql.ID
+	Kind        string
+	Directed    bool
+	Description *string
+}
+
+// AddRelationship connects two characters. The logged in user must
+// own the source character and be allowed to see the target character.
+func (r *Resolver) AddRelationship(ctx context.Context, args *struct {
+	Rel *relationshipInput
+}) (*relationshipResolver, error) {
+	from, err := ownedCharacter(ctx, args.Rel.From)
+	if err != nil {
+		return nil, err
+	}
+	to := visibleCharacter(ctx, localID(characterKind, args.Rel.To))
+	if to == nil {
+		return nil, errors.Errorf("Character %s not found", args.Rel.To)
+	}
+	if from.ID == to.gameCharacter.ID {
+		return nil, errors.New("A character can't have a relationship with itself")
+	}
+
+	rel := &relationship{
+		ID:       graphql.ID(xid.New().String()),
+		From:     from.ID,
+		To:       to.gameCharacter.ID,
+		Kind:     args.Rel.Kind,
+		Directed: args.Rel.Directed,
+		Owner:    from.Owner,
+	}
+	if args.Rel.Description != nil {
+		rel.Description = *args.Rel.Description
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		return putRelationship(tx, rel)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &relationshipResolver{rel}, nil
+}
+
+// RemoveRelationship deletes a relationship created by the logged in user
+func (r *Resolver) RemoveRelationship(ctx context.Context, args *struct {
+	ID graphql.ID
+}) *resultResolver {
+	res := result{
+		Op:    "delete",
+		Count: 0,
+	}
+
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+		return &resultResolver{&res}
+	}
+
+	db.Update(func(tx *bolt.Tx) error {
+		rel := readRelationship(tx, args.ID)
+		if rel == nil {
+			return nil
+		}
+		if auth.Authenticated && rel.Owner == auth.UserName {
+			res.Count = 1
+			return deleteRelationship(tx, rel)
+		}
+		return nil
+	})
+
+	return &resultResolver{&res}
+}
@@ -0,0 +1,9 @@
+package data
+
+import _ "embed"
+
+// Schema is the GraphQL schema in the schema language. It is compiled
+// into the binary, so the server can be started from any directory.
+//
+//go:embed gamecharacters.gql
+var Schema string
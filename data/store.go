@@ -0,0 +1,244 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	bolt "github.com/coreos/bbolt"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// gameCharactersBucket holds all characters including those in the trash.
+// Every read unmarshals a new copy, so resolvers never share a character
+// which is changed concurrently. All changes are done in transactions.
+var gameCharactersBucket = []byte("GameCharacters")
+
+// readCharacter loads a character from the database, trashed or not.
+// Returns nil if it doesn't exist.
+func readCharacter(tx *bolt.Tx, id graphql.ID) *gameCharacter {
+	if id == "" {
+		return nil
+	}
+	entry := tx.Bucket(gameCharactersBucket).Get([]byte(id))
+	if entry == nil {
+		return nil
+	}
+	var gc gameCharacter
+	if err := json.Unmarshal(entry, &gc); err != nil {
+		fmt.Printf("Unable to read character %s: %s\n", id, err)
+		return nil
+	}
+	return &gc
+}
+
+// activeCharacter loads a character which is not in the trash
+func activeCharacter(tx *bolt.Tx, id graphql.ID) *gameCharacter {
+	if gc := readCharacter(tx, id); gc != nil && gc.DeletedAt.IsZero() {
+		return gc
+	}
+	return nil
+}
+
+// getCharacter loads a character which is not in the trash in its own transaction
+func getCharacter(id graphql.ID) *gameCharacter {
+	var gc *gameCharacter
+	db.View(func(tx *bolt.Tx) error {
+		gc = activeCharacter(tx, id)
+		return nil
+	})
+	return gc
+}
+
+// charactersByGameBucket is an index which holds one nested bucket per game
+// with the IDs of all characters appearing in it as keys. Trashed characters
+// stay in the index until they are purged.
+var charactersByGameBucket = []byte("CharactersByGame")
+
+// gameIDs returns the games the character appeared in, including its debut game
+func (gc *gameCharacter) gameIDs() []graphql.ID {
+	var ids []graphql.ID
+	if gc.GameID != "" {
+		ids = append(ids, gc.GameID)
+	}
+	for _, a := range gc.Appearances {
+		if a.GameID != gc.GameID {
+			ids = append(ids, a.GameID)
+		}
+	}
+	return ids
+}
+
+// indexCharacterGames updates the index of the games after a character
+// changed from old to gc. Either may be nil for added or deleted characters.
+func indexCharacterGames(tx *bolt.Tx, old *gameCharacter, gc *gameCharacter) error {
+	index := tx.Bucket(charactersByGameBucket)
+	current := make(map[graphql.ID]bool)
+	var id graphql.ID
+	if gc != nil {
+		id = gc.ID
+		for _, gameID := range gc.gameIDs() {
+			current[gameID] = true
+		}
+	}
+
+	if old != nil {
+		id = old.ID
+		for _, gameID := range old.gameIDs() {
+			if current[gameID] {
+				continue
+			}
+			if b := index.Bucket([]byte(gameID)); b != nil {
+				if err := b.Delete([]byte(id)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for gameID := range current {
+		b, err := index.CreateBucketIfNotExists([]byte(gameID))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(id), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// characterIDsOfGame reads the IDs of all characters which appeared in the game from the index
+func characterIDsOfGame(tx *bolt.Tx, gameID graphql.ID) []graphql.ID {
+	var ids []graphql.ID
+	if b := tx.Bucket(charactersByGameBucket).Bucket([]byte(gameID)); b != nil {
+		b.ForEach(func(k, v []byte) error {
+			ids = append(ids, graphql.ID(k))
+			return nil
+		})
+	}
+	return ids
+}
+
+// putCharacter stores a character and keeps the index of the games up to date
+func putCharacter(tx *bolt.Tx, gc *gameCharacter) error {
+	gcJSON, err := json.Marshal(gc)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal character to JSON")
+	}
+	old := readCharacter(tx, gc.ID)
+	if err := tx.Bucket(gameCharactersBucket).Put([]byte(gc.ID), gcJSON); err != nil {
+		return err
+	}
+	return indexCharacterGames(tx, old, gc)
+}
+
+// removeCharacter deletes the record of a character and its index entries.
+// Everything else referring to the character must be deleted by the caller.
+func removeCharacter(tx *bolt.Tx, id graphql.ID) error {
+	if err := indexCharacterGames(tx, readCharacter(tx, id), nil); err != nil {
+		return err
+	}
+	return tx.Bucket(gameCharactersBucket).Delete([]byte(id))
+}
+
+// forEachCharacter calls fn for every character including those in the trash
+func forEachCharacter(tx *bolt.Tx, fn func(gc *gameCharacter) error) error {
+	return tx.Bucket(gameCharactersBucket).ForEach(func(k, v []byte) error {
+		var gc gameCharacter
+		if err := json.Unmarshal(v, &gc); err != nil {
+			fmt.Printf("Unable to read character %s: %s\n", k, err)
+			return nil
+		}
+		return fn(&gc)
+	})
+}
+
+// allCharacters loads all characters which are not in the trash ordered by ID
+func allCharacters() []*gameCharacter {
+	var characters []*gameCharacter
+	db.View(func(tx *bolt.Tx) error {
+		return forEachCharacter(tx, func(gc *gameCharacter) error {
+			if gc.DeletedAt.IsZero() {
+				characters = append(characters, gc)
+			}
+			return nil
+		})
+	})
+	return characters
+}
+
+// conflictError is returned if a character was changed after the client read it.
+// The current version is sent along, so the client can reload and try again.
+type conflictError struct {
+	id      graphql.ID
+	version int32
+}
+
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("Character %s was changed in the meantime, the current version is %d", e.id, e.version)
+}
+
+// Extensions is added to the GQL error by graphql-go
+func (e *conflictError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":           "CONFLICT",
+		"currentVersion": e.version,
+	}
+}
+
+// checkVersion makes sure the client changes the version of the character
+// it has seen and increases the version for the change
+func checkVersion(gc *gameCharacter, version int32) error {
+	if gc.Version != version {
+		return &conflictError{globalID(characterKind, gc.ID), gc.Version}
+	}
+	gc.Version++
+	return nil
+}
+
+// updateOwnedCharacter changes a character of the logged in user in one
+// transaction and notifies the subscribers about the change. The ID is
+// the global ID as sent by the client, the version is the one the client has seen.
+func updateOwnedCharacter(ctx context.Context, id graphql.ID, version int32, update func(tx *bolt.Tx, gc *gameCharacter) error) (*gameCharacter, error) {
+	gc, err := ownedCharacter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		// Read the character again as it might have changed in the meantime
+		gc = activeCharacter(tx, gc.ID)
+		if gc == nil {
+			return errors.Errorf("Character %s not found", id)
+		}
+		if err := checkVersion(gc, version); err != nil {
+			return err
+		}
+		if err := update(tx, gc); err != nil {
+			return err
+		}
+		return putCharacter(tx, gc)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	forgetCharacter(ctx, gc.ID)
+	characterEvents.publish(topicCharacterUpdated, gc)
+	return gc, nil
+}
+
+// seedCharacters stores some hardcoded characters to work with if the
+// database has no characters yet
+func seedCharacters(tx *bolt.Tx) error {
+	if k, _ := tx.Bucket(gameCharactersBucket).Cursor().First(); k != nil {
+		return nil
+	}
+	for _, gc := range gameCharacters {
+		if err := putCharacter(tx, gc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
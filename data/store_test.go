@@ -0,0 +1,200 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/migrations"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// openTestDB opens an empty database in a temporary directory with the
+// buckets the server creates at startup and applies all migrations
+func openTestDB(t *testing.T) {
+	database, err := bolt.Open(filepath.Join(t.TempDir(), "gamechars.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	err = database.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			usersBucket, gameCharactersBucket, gamesBucket, charactersByGameBucket,
+			relationshipsBucket, relationshipsByCharacterBucket, characterTagsBucket,
+			tagsBucket, collectionsBucket, favoritesBucket, favoriteCountsBucket,
+			commentsBucket, commentThreadsBucket, linkStatusBucket,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetDB(database)
+	if _, err := migrations.Run(database, Migrations); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// requestContext creates the context of a request of a logged in user
+func requestContext(userName string) context.Context {
+	return WithLoaders(utils.PutContextAuthData(context.Background(), true, userName))
+}
+
+// TestConcurrentReadsAndWrites adds, removes, restores, favorites and tags
+// characters while others list and read them. Run it with -race.
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	openTestDB(t)
+	r := &Resolver{}
+	seeded := len(*mustList(t, r, requestContext("reader"), nil))
+
+	const writers = 4
+	const iterations = 20
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	errs := make(chan error, writers)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(userName string) {
+			defer wg.Done()
+			errs <- writeCharacters(r, userName, iterations)
+		}(fmt.Sprintf("writer%d", w))
+	}
+
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func(userName string) {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				readCharacters(r, requestContext(userName))
+			}
+		}(fmt.Sprintf("writer%d", i))
+	}
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := requestContext("reader")
+	if n := len(*mustList(t, r, ctx, nil)); n != seeded+writers*iterations {
+		t.Errorf("got %d characters, want %d", n, seeded+writers*iterations)
+	}
+	if n := len(*mustList(t, r, ctx, []string{"race"})); n != writers*iterations {
+		t.Errorf("got %d tagged characters, want %d", n, writers*iterations)
+	}
+	var raceGames []*gameResolver
+	for _, g := range *r.Games() {
+		if g.Title() == "Race Condition" {
+			raceGames = append(raceGames, g)
+		}
+	}
+	if len(raceGames) != 1 {
+		t.Fatalf("got %d games shared by the writers, want 1", len(raceGames))
+	}
+	if n := len(*raceGames[0].Characters(ctx)); n != writers*iterations {
+		t.Errorf("got %d characters of the shared game, want %d", n, writers*iterations)
+	}
+	for w := 0; w < writers; w++ {
+		userName := fmt.Sprintf("writer%d", w)
+		if n := len(r.Favorites(requestContext(userName))); n != iterations {
+			t.Errorf("%s has %d favorites, want %d", userName, n, iterations)
+		}
+	}
+}
+
+// writeCharacters runs all mutations of a character's life cycle as the user
+func writeCharacters(r *Resolver, userName string, iterations int) error {
+	// All writers share the game, so it is created concurrently as well
+	debutGame := "Race Condition"
+	for i := 0; i < iterations; i++ {
+		gc, err := r.AddCharacter(&struct{ Char *gameCharacterInput }{&gameCharacterInput{
+			Name:      fmt.Sprintf("%s character %d", userName, i),
+			DebutGame: &debutGame,
+			Public:    true,
+			Owner:     userName,
+		}})
+		if err != nil {
+			return err
+		}
+		id := gc.ID()
+
+		if _, err := r.FavoriteCharacter(requestContext(userName), &struct{ ID graphql.ID }{id}); err != nil {
+			return err
+		}
+		tagged, err := r.TagCharacter(requestContext(userName), &struct {
+			ID      graphql.ID
+			Version int32
+			Tags    []string
+		}{id, gc.Version(), []string{"race"}})
+		if err != nil {
+			return err
+		}
+
+		res, err := r.RemoveCharacter(requestContext(userName), &struct {
+			ID      graphql.ID
+			Version int32
+		}{id, tagged.Version()})
+		if err != nil {
+			return err
+		}
+		if res.Count() != 1 {
+			return fmt.Errorf("%s was not removed", id)
+		}
+		if r.RestoreCharacter(requestContext(userName), &struct{ ID graphql.ID }{id}) == nil {
+			return fmt.Errorf("%s was not restored", id)
+		}
+	}
+	return nil
+}
+
+// readCharacters runs the list and read queries and resolves their fields
+func readCharacters(r *Resolver, ctx context.Context) {
+	list, err := r.GameCharacters(ctx, struct{ Tags *[]string }{})
+	if err != nil {
+		return
+	}
+	for _, gcr := range *list {
+		gcr.Name()
+		gcr.Tags(ctx)
+		gcr.FavoriteCount(ctx)
+		r.GameCharacter(ctx, struct{ ID graphql.ID }{gcr.ID()})
+	}
+	r.Favorites(ctx)
+	r.PopularCharacters(ctx, struct{ Limit int32 }{10})
+	r.TrashedCharacters(ctx)
+	r.Tags(ctx)
+}
+
+func mustList(t *testing.T, r *Resolver, ctx context.Context, tags []string) *[]*gameCharacterResolver {
+	args := struct{ Tags *[]string }{}
+	if tags != nil {
+		args.Tags = &tags
+	}
+	list, err := r.GameCharacters(ctx, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
@@ -0,0 +1,289 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// characterTagsBucket maps a character ID to a JSON list of its tags
+var characterTagsBucket = []byte("CharacterTags")
+
+// tagsBucket is the reverse index. It holds one nested bucket per tag
+// which contains the IDs of all characters with this tag as keys.
+var tagsBucket = []byte("Tags")
+
+const maxTagLength = 32
+
+// categories are curated tags which are highlighted in the frontend
+var categories = []string{
+	"ai",
+	"alien",
+	"antagonist",
+	"fantasy",
+	"horror",
+	"protagonist",
+	"robot",
+	"sci-fi",
+	"sidekick",
+}
+
+// normalizeTag converts a tag to the form it is stored in
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || len(tag) > maxTagLength {
+		return "", errors.Errorf("Tags must be between 1 and %d characters long", maxTagLength)
+	}
+	return tag, nil
+}
+
+func isCategory(tag string) bool {
+	for _, c := range categories {
+		if c == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// characterTags loads the tags of a character
+func characterTags(id graphql.ID) []string {
+	var tags []string
+	db.View(func(tx *bolt.Tx) error {
+		tags = readCharacterTags(tx, id)
+		return nil
+	})
+	return tags
+}
+
+func readCharacterTags(tx *bolt.Tx, id graphql.ID) []string {
+	var tags []string
+	entry := tx.Bucket(characterTagsBucket).Get([]byte(id))
+	if entry == nil {
+		return nil
+	}
+	if err := json.Unmarshal(entry, &tags); err != nil {
+		fmt.Printf("Unable to read tags of character %s: %s\n", id, err)
+		return nil
+	}
+	return tags
+}
+
+// setCharacterTags stores the tags of a character and updates the reverse index
+func setCharacterTags(tx *bolt.Tx, id graphql.ID, tags []string) error {
+	old := tagSet(nil)
+	if entry := tx.Bucket(characterTagsBucket).Get([]byte(id)); entry != nil {
+		var oldTags []string
+		if err := json.Unmarshal(entry, &oldTags); err != nil {
+			return err
+		}
+		old = tagSet(oldTags)
+	}
+	current := tagSet(tags)
+
+	index := tx.Bucket(tagsBucket)
+	for tag := range old {
+		if current[tag] {
+			continue
+		}
+		if b := index.Bucket([]byte(tag)); b != nil {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+			if k, _ := b.Cursor().First(); k == nil {
+				if err := index.DeleteBucket([]byte(tag)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for tag := range current {
+		b, err := index.CreateBucketIfNotExists([]byte(tag))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(id), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	if len(tags) == 0 {
+		return tx.Bucket(characterTagsBucket).Delete([]byte(id))
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal tags to JSON")
+	}
+	return tx.Bucket(characterTagsBucket).Put([]byte(id), tagsJSON)
+}
+
+// deleteTagsOf removes a character from the tag index
+func deleteTagsOf(tx *bolt.Tx, id graphql.ID) error {
+	return setCharacterTags(tx, id, nil)
+}
+
+// taggedCharacterIDs returns the IDs of all characters which have all of the given tags
+func taggedCharacterIDs(tags []string) map[graphql.ID]bool {
+	ids := make(map[graphql.ID]bool)
+	db.View(func(tx *bolt.Tx) error {
+		for i, tag := range tags {
+			matches := make(map[graphql.ID]bool)
+			if b := tx.Bucket(tagsBucket).Bucket([]byte(tag)); b != nil {
+				b.ForEach(func(k, v []byte) error {
+					if i == 0 || ids[graphql.ID(k)] {
+						matches[graphql.ID(k)] = true
+					}
+					return nil
+				})
+			}
+			ids = matches
+		}
+		return nil
+	})
+	return ids
+}
+
+func tagSet(tags []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, tag := range tags {
+		set[tag] = true
+	}
+	return set
+}
+
+func (gcr *gameCharacterResolver) Tags(ctx context.Context) []string {
+	tags := loadCharacterTags(ctx, gcr.gameCharacter.ID)
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// tag corresponds to the GQL type Tag
+type tag struct {
+	Name  string
+	Count int32
+}
+
+type tagResolver struct {
+	tag *tag
+}
+
+func (tr *tagResolver) Name() string {
+	return tr.tag.Name
+}
+
+func (tr *tagResolver) Category() bool {
+	return isCategory(tr.tag.Name)
+}
+
+func (tr *tagResolver) Count() int32 {
+	return tr.tag.Count
+}
+
+// Tags gets all tags with the number of characters visible to the user.
+// Categories are always returned, even if no character uses them yet.
+func (r *Resolver) Tags(ctx context.Context) []*tagResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	counts := make(map[string]int32)
+	for _, c := range categories {
+		counts[c] = 0
+	}
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tagsBucket).ForEach(func(name, v []byte) error {
+			b := tx.Bucket(tagsBucket).Bucket(name)
+			if b == nil {
+				return nil
+			}
+			var count int32
+			b.ForEach(func(id, v []byte) error {
+				if gc := activeCharacter(tx, graphql.ID(id)); gc != nil && gc.visibleTo(auth) {
+					count++
+				}
+				return nil
+			})
+			if count > 0 || isCategory(string(name)) {
+				counts[string(name)] = count
+			}
+			return nil
+		})
+	})
+
+	var tags []*tagResolver
+	for name, count := range counts {
+		tags = append(tags, &tagResolver{&tag{Name: name, Count: count}})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].tag.Name < tags[j].tag.Name
+	})
+	return tags
+}
+
+// updateTags adds or removes tags of a character owned by the logged in user.
+// Tags are part of the character, so the change increases its version.
+func updateTags(ctx context.Context, id graphql.ID, version int32, tags []string, add bool) (*gameCharacterResolver, error) {
+	changed := make(map[string]bool)
+	for _, t := range tags {
+		normalized, err := normalizeTag(t)
+		if err != nil {
+			return nil, err
+		}
+		changed[normalized] = true
+	}
+
+	gc, err := updateOwnedCharacter(ctx, id, version, func(tx *bolt.Tx, gc *gameCharacter) error {
+		var current []string
+		if entry := tx.Bucket(characterTagsBucket).Get([]byte(gc.ID)); entry != nil {
+			if err := json.Unmarshal(entry, &current); err != nil {
+				return err
+			}
+		}
+
+		var updated []string
+		for _, t := range current {
+			if !changed[t] {
+				updated = append(updated, t)
+			}
+		}
+		if add {
+			for t := range changed {
+				updated = append(updated, t)
+			}
+		}
+		sort.Strings(updated)
+		return setCharacterTags(tx, gc.ID, updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gameCharacterResolver{gc}, nil
+}
+
+// TagCharacter adds tags to a character
+func (r *Resolver) TagCharacter(ctx context.Context, args *struct {
+	ID      graphql.ID
+	Version int32
+	Tags    []string
+}) (*gameCharacterResolver, error) {
+	return updateTags(ctx, args.ID, args.Version, args.Tags, true)
+}
+
+// UntagCharacter removes tags from a character
+func (r *Resolver) UntagCharacter(ctx context.Context, args *struct {
+	ID      graphql.ID
+	Version int32
+	Tags    []string
+}) (*gameCharacterResolver, error) {
+	return updateTags(ctx, args.ID, args.Version, args.Tags, false)
+}
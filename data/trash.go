@@ -0,0 +1,198 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// Characters in the trash stay in the GameCharacters bucket with DeletedAt set
+
+// trashCharacter moves a character of the given owner to the trash.
+// Returns nil if there is no such character.
+func trashCharacter(id graphql.ID, owner string, version int32) (*gameCharacter, error) {
+	var gc *gameCharacter
+	err := db.Update(func(tx *bolt.Tx) error {
+		gc = activeCharacter(tx, id)
+		if gc == nil || gc.Owner != owner {
+			gc = nil
+			return nil
+		}
+		if err := checkVersion(gc, version); err != nil {
+			gc = nil
+			return err
+		}
+		gc.DeletedAt = time.Now()
+		return putCharacter(tx, gc)
+	})
+	return gc, err
+}
+
+// deleteCharacter permanently deletes a character of the trash and
+// everything which refers to it in one transaction. If owner is empty, neither
+// the owner nor the version are checked. Returns whether the character was deleted.
+func deleteCharacter(id graphql.ID, owner string, version int32) (bool, error) {
+	deleted := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		gc := readCharacter(tx, id)
+		if gc == nil || gc.DeletedAt.IsZero() || (owner != "" && gc.Owner != owner) {
+			return nil
+		}
+		if owner != "" {
+			if err := checkVersion(gc, version); err != nil {
+				return err
+			}
+		}
+
+		if err := deleteRelationshipsOf(tx, id); err != nil {
+			return errors.Wrap(err, "Unable to delete the relationships")
+		}
+		if err := deleteTagsOf(tx, id); err != nil {
+			return errors.Wrap(err, "Unable to delete the tags")
+		}
+		if err := deleteFavoritesOf(tx, id); err != nil {
+			return errors.Wrap(err, "Unable to delete the favorites")
+		}
+		if err := deleteCommentsOf(tx, id); err != nil {
+			return errors.Wrap(err, "Unable to delete the comments")
+		}
+		deleted = true
+		return removeCharacter(tx, id)
+	})
+	if err != nil {
+		deleted = false
+	}
+	return deleted, err
+}
+
+// purgeExpiredCharacters permanently deletes all characters which
+// have been in the trash for longer than the given retention
+func purgeExpiredCharacters(retention time.Duration) int {
+	var expired []graphql.ID
+	deadline := time.Now().Add(-retention)
+	db.View(func(tx *bolt.Tx) error {
+		return forEachCharacter(tx, func(gc *gameCharacter) error {
+			if !gc.DeletedAt.IsZero() && gc.DeletedAt.Before(deadline) {
+				expired = append(expired, gc.ID)
+			}
+			return nil
+		})
+	})
+
+	purged := 0
+	for _, id := range expired {
+		deleted, err := deleteCharacter(id, "", 0)
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+		if deleted {
+			purged++
+		}
+	}
+	return purged
+}
+
+// StartTrashPurger starts a background job which checks the trash every
+// interval and purges all characters older than the given retention.
+func StartTrashPurger(retention time.Duration, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if purged := purgeExpiredCharacters(retention); purged > 0 {
+				fmt.Printf("Purged %d characters from the trash.\n", purged)
+			}
+		}
+	}()
+}
+
+// TrashedCharacters gets all characters in the trash of the logged in user
+func (r *Resolver) TrashedCharacters(ctx context.Context) *[]*gameCharacterResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	var gameChars []*gameCharacterResolver
+	db.View(func(tx *bolt.Tx) error {
+		return forEachCharacter(tx, func(gc *gameCharacter) error {
+			if !gc.DeletedAt.IsZero() && auth.Authenticated && gc.Owner == auth.UserName {
+				gameChars = append(gameChars, &gameCharacterResolver{gc})
+			}
+			return nil
+		})
+	})
+
+	return &gameChars
+}
+
+// RestoreCharacter moves a character from the trash back to the active characters
+func (r *Resolver) RestoreCharacter(ctx context.Context, args *struct {
+	ID graphql.ID
+}) *gameCharacterResolver {
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil
+	}
+	if !auth.Authenticated {
+		return nil
+	}
+
+	var gc *gameCharacter
+	err = db.Update(func(tx *bolt.Tx) error {
+		gc = readCharacter(tx, localID(characterKind, args.ID))
+		if gc == nil || gc.DeletedAt.IsZero() || gc.Owner != auth.UserName {
+			gc = nil
+			return nil
+		}
+		gc.DeletedAt = time.Time{}
+		gc.Version++
+		return putCharacter(tx, gc)
+	})
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil
+	}
+	if gc == nil {
+		return nil
+	}
+
+	forgetCharacter(ctx, gc.ID)
+	characterEvents.publish(topicCharacterAdded, gc)
+	return &gameCharacterResolver{gc}
+}
+
+// PurgeCharacter permanently deletes a character from the trash
+func (r *Resolver) PurgeCharacter(ctx context.Context, args *struct {
+	ID      graphql.ID
+	Version int32
+}) (*resultResolver, error) {
+	res := result{
+		Op:    "purge",
+		Count: 0,
+	}
+
+	auth, err := utils.GetContextAuthData(ctx)
+	if err != nil {
+		fmt.Println(err.Error())
+		return &resultResolver{&res}, nil
+	}
+	if !auth.Authenticated {
+		return &resultResolver{&res}, nil
+	}
+
+	id := localID(characterKind, args.ID)
+	deleted, err := deleteCharacter(id, auth.UserName, args.Version)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		res.Count = 1
+		forgetCharacter(ctx, id)
+	}
+	return &resultResolver{&res}, nil
+}
@@ -0,0 +1,36 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/utils"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// TestRestoreCharacterRequiresLogin restores a trashed character without
+// an owner, which anonymous users must not be able to do
+func TestRestoreCharacterRequiresLogin(t *testing.T) {
+	openTestDB(t)
+	r := &Resolver{}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		return putCharacter(tx, &gameCharacter{ID: "orphan", Name: "Orphan", Version: 1, DeletedAt: time.Now()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anonymous := WithLoaders(utils.PutContextAuthData(context.Background(), false, ""))
+	if r.RestoreCharacter(anonymous, &struct{ ID graphql.ID }{"orphan"}) != nil {
+		t.Error("an anonymous user restored a character")
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if gc := readCharacter(tx, "orphan"); gc == nil || gc.DeletedAt.IsZero() {
+			t.Error("the character is no longer in the trash")
+		}
+		return nil
+	})
+}
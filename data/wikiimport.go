@@ -0,0 +1,294 @@
+package data
+
+import (
+	"io"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/wiki"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+// provenance records the wiki article a character was imported from
+type provenance struct {
+	Page         string
+	URL          string
+	Revision     string
+	RevisionDate time.Time
+	ImportedAt   time.Time
+}
+
+// WikiImportResult is the outcome of an import from a wiki dump
+type WikiImportResult struct {
+	Created int
+	Updated int
+	DryRun  bool
+	// Skipped are the titles of pages without an infobox
+	Skipped []string
+	// Missing are the requested titles which aren't in the dump
+	Missing []string
+}
+
+// forEachWikiPage calls fn for each page with one of the given titles or
+// in the given category as the dump is read. Returns the titles which
+// aren't in the dump.
+func forEachWikiPage(d *wiki.Dump, titles []string, category string, fn func(p *wiki.Page) error) ([]string, error) {
+	wanted := make(map[string]bool)
+	for _, t := range titles {
+		wanted[wiki.NormalizeTitle(t)] = true
+	}
+
+	for {
+		p, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if wanted[p.Title] || (category != "" && p.InCategory(category)) {
+			delete(wanted, p.Title)
+			if err := fn(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var missing []string
+	for _, t := range titles {
+		if wanted[wiki.NormalizeTitle(t)] {
+			missing = append(missing, t)
+		}
+	}
+	return missing, nil
+}
+
+// wikiCharacterIndex maps the titles of wiki pages to the owner's characters
+// imported from the page and, for characters created by hand, the ones
+// linking the page. Characters in the trash are ignored.
+type wikiCharacterIndex struct {
+	bySource map[string]graphql.ID
+	byLink   map[string]graphql.ID
+}
+
+// newWikiCharacterIndex reads the index of the owner's characters once, so
+// the characters don't have to be read again for every page of a dump
+func newWikiCharacterIndex(tx *bolt.Tx, owner string) (*wikiCharacterIndex, error) {
+	index := &wikiCharacterIndex{
+		bySource: make(map[string]graphql.ID),
+		byLink:   make(map[string]graphql.ID),
+	}
+	err := forEachCharacter(tx, func(gc *gameCharacter) error {
+		if !gc.DeletedAt.IsZero() || gc.Owner != owner {
+			// Only the owner's active characters are updated
+			return nil
+		}
+		if gc.Source != nil {
+			index.bySource[gc.Source.Page] = gc.ID
+		}
+		if gc.Wiki != "" {
+			title := wiki.TitleFromURL(gc.Wiki)
+			if _, ok := index.byLink[title]; !ok {
+				index.byLink[title] = gc.ID
+			}
+		}
+		return nil
+	})
+	return index, err
+}
+
+// find returns the owner's character for the page, nil if there is none
+func (index *wikiCharacterIndex) find(tx *bolt.Tx, title string, owner string) *gameCharacter {
+	for _, ids := range []map[string]graphql.ID{index.bySource, index.byLink} {
+		if id, ok := ids[title]; ok {
+			if gc := activeCharacter(tx, id); gc != nil && gc.Owner == owner {
+				return gc
+			}
+		}
+	}
+	return nil
+}
+
+// applyInfobox sets the fields of the character found in the infobox.
+// Fields missing in the infobox are left as they are.
+func (gc *gameCharacter) applyInfobox(tx *bolt.Tx, d *wiki.Dump, p *wiki.Page, box *wiki.Infobox) error {
+	if box.Name != "" {
+		gc.Name = box.Name
+	}
+	if box.FirstGame != "" {
+		g, err := gameForDebut(tx, box.FirstGame, box.FirstYear)
+		if err != nil {
+			return err
+		}
+		gc.GameID = g.ID
+	}
+	if box.Creator != "" {
+		gc.Creator = box.Creator
+	}
+	if box.Image != "" {
+		gc.Img = d.FileURL(box.Image)
+	}
+	if gc.Wiki == "" {
+		gc.Wiki = d.URL(p.Title)
+	}
+	gc.Source = &provenance{
+		Page:         p.Title,
+		URL:          d.URL(p.Title),
+		Revision:     p.Revision,
+		RevisionDate: p.Timestamp,
+		ImportedAt:   time.Now(),
+	}
+	return nil
+}
+
+// wikiImportBatchSize is the number of pages imported in one transaction.
+// The dump is read between the transactions, so other writers aren't
+// blocked while a large dump is imported.
+const wikiImportBatchSize = 50
+
+// ImportWikiPages creates or updates characters from the infoboxes of the
+// pages with the given titles or in the given category. The pages are
+// imported in batches as the dump is read, so on an error the batches
+// before stay imported. Existing characters of the owner are found by the
+// page they were imported from or their wiki link. New characters are
+// non-playable and belong to owner. Nothing is stored if dryRun is set.
+func ImportWikiPages(r io.Reader, titles []string, category string, owner string, public bool, dryRun bool) (*WikiImportResult, error) {
+	if len(titles) == 0 && category == "" {
+		return nil, errors.New("Either titles or a category are required")
+	}
+	if owner == "" {
+		return nil, errors.New("The owner of the characters is required")
+	}
+
+	var index *wikiCharacterIndex
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		index, err = newWikiCharacterIndex(tx, owner)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := wiki.NewDump(r)
+	res := &WikiImportResult{DryRun: dryRun}
+	var batch []*wiki.Page
+	flush := func() error {
+		err := importWikiBatch(d, batch, index, owner, public, dryRun, res)
+		batch = nil
+		return err
+	}
+
+	missing, err := forEachWikiPage(d, titles, category, func(p *wiki.Page) error {
+		batch = append(batch, p)
+		if len(batch) < wikiImportBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	res.Missing = missing
+	return res, nil
+}
+
+// importWikiBatch imports the pages in one transaction and adds them to
+// the result. The transaction is rolled back if dryRun is set.
+func importWikiBatch(d *wiki.Dump, pages []*wiki.Page, index *wikiCharacterIndex, owner string, public bool, dryRun bool, res *WikiImportResult) error {
+	if len(pages) == 0 {
+		return nil
+	}
+
+	var created, updated []*gameCharacter
+	var skipped []string
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, p := range pages {
+			box := p.ParseInfobox()
+			if box == nil {
+				skipped = append(skipped, p.Title)
+				continue
+			}
+
+			gc := index.find(tx, p.Title, owner)
+			if gc != nil {
+				// The revision is already imported
+				if gc.Source != nil && gc.Source.Revision == p.Revision {
+					continue
+				}
+				gc.Version++
+				updated = append(updated, gc)
+			} else {
+				gc = &gameCharacter{
+					ID:      graphql.ID(xid.New().String()),
+					Name:    p.Title,
+					Public:  public,
+					Owner:   owner,
+					Kind:    kindNonPlayable,
+					Version: 1,
+				}
+				created = append(created, gc)
+			}
+
+			if err := gc.applyInfobox(tx, d, p, box); err != nil {
+				return errors.Wrapf(err, "Unable to import %s", p.Title)
+			}
+			if err := putCharacter(tx, gc); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && err != errRollback {
+		return err
+	}
+
+	res.Skipped = append(res.Skipped, skipped...)
+	res.Created += len(created)
+	res.Updated += len(updated)
+	if dryRun {
+		return nil
+	}
+
+	for _, gc := range created {
+		index.bySource[gc.Source.Page] = gc.ID
+		characterEvents.publish(topicCharacterAdded, gc)
+	}
+	for _, gc := range updated {
+		index.bySource[gc.Source.Page] = gc.ID
+		characterEvents.publish(topicCharacterUpdated, gc)
+	}
+	return nil
+}
+
+type provenanceResolver struct {
+	provenance *provenance
+}
+
+func (pr *provenanceResolver) Page() string {
+	return pr.provenance.Page
+}
+
+func (pr *provenanceResolver) URL() string {
+	return pr.provenance.URL
+}
+
+func (pr *provenanceResolver) Revision() graphql.ID {
+	return graphql.ID(pr.provenance.Revision)
+}
+
+func (pr *provenanceResolver) RevisionDate() string {
+	return pr.provenance.RevisionDate.Format(time.RFC3339)
+}
+
+func (pr *provenanceResolver) ImportedAt() string {
+	return pr.provenance.ImportedAt.Format(time.RFC3339)
+}
@@ -0,0 +1,169 @@
+package limits
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenNumber
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// lexer splits GraphQL documents into tokens. Whitespace, commas, byte
+// order marks and comments are insignificant in GraphQL and are skipped.
+type lexer struct {
+	input string
+	pos   int
+	token token
+}
+
+func newLexer(input string) (*lexer, error) {
+	l := &lexer{input: input}
+	if err := l.next(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// next advances to the next token
+func (l *lexer) next() error {
+	l.skipIgnored()
+	if l.pos >= len(l.input) {
+		l.token = token{kind: tokenEOF}
+		return nil
+	}
+
+	start := l.pos
+	c := l.input[l.pos]
+	switch {
+	case strings.HasPrefix(l.input[l.pos:], "..."):
+		l.pos += 3
+		l.token = token{tokenPunctuator, "..."}
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		l.pos++
+		l.token = token{tokenPunctuator, string(c)}
+	case isNameStart(c):
+		for l.pos < len(l.input) && isNameContinue(l.input[l.pos]) {
+			l.pos++
+		}
+		l.token = token{tokenName, l.input[start:l.pos]}
+	case c == '-' || isDigit(c):
+		l.pos++
+		for l.pos < len(l.input) && strings.IndexByte("0123456789.eE+-", l.input[l.pos]) >= 0 {
+			l.pos++
+		}
+		l.token = token{tokenNumber, l.input[start:l.pos]}
+	case strings.HasPrefix(l.input[l.pos:], `"""`):
+		end := strings.Index(l.input[l.pos+3:], `"""`)
+		if end < 0 {
+			return fmt.Errorf("unterminated block string")
+		}
+		l.pos += end + 6
+		l.token = token{tokenString, l.input[start+3 : l.pos-3]}
+	case c == '"':
+		l.pos++
+		for l.pos < len(l.input) && l.input[l.pos] != '"' {
+			if l.input[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.input) {
+			return fmt.Errorf("unterminated string")
+		}
+		l.pos++
+		l.token = token{tokenString, l.input[start+1 : l.pos-1]}
+	default:
+		return fmt.Errorf("unexpected character %q", c)
+	}
+	return nil
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.input) {
+		switch c := l.input[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case strings.HasPrefix(l.input[l.pos:], "\ufeff"):
+			l.pos += len("\ufeff")
+		case c == '#':
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek checks whether the current token is the given punctuator or name
+func (l *lexer) peek(value string) bool {
+	return (l.token.kind == tokenPunctuator || l.token.kind == tokenName) && l.token.value == value
+}
+
+// skip advances if the current token is the given punctuator or name
+func (l *lexer) skip(value string) (bool, error) {
+	if !l.peek(value) {
+		return false, nil
+	}
+	return true, l.next()
+}
+
+func (l *lexer) expect(value string) error {
+	if !l.peek(value) {
+		return fmt.Errorf("expected %q, found %q", value, l.token.value)
+	}
+	return l.next()
+}
+
+func (l *lexer) expectName() (string, error) {
+	if l.token.kind != tokenName {
+		return "", fmt.Errorf("expected name, found %q", l.token.value)
+	}
+	name := l.token.value
+	return name, l.next()
+}
+
+// skipBalanced skips a block started by open including all nested blocks
+func (l *lexer) skipBalanced(open, close string) error {
+	if err := l.expect(open); err != nil {
+		return err
+	}
+	for depth := 1; depth > 0; {
+		if l.token.kind == tokenEOF {
+			return fmt.Errorf("expected %q", close)
+		}
+		if l.peek(open) {
+			depth++
+		} else if l.peek(close) {
+			depth--
+		}
+		if err := l.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
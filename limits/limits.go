@@ -0,0 +1,180 @@
+// Package limits protects a GraphQL server against expensive queries by
+// checking their estimated complexity before they are executed. The depth
+// of queries is limited by graphql-go itself with the graphql.MaxDepth
+// schema option.
+package limits
+
+import (
+	"strings"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+// FieldCost overrides how a field contributes to the complexity of a query
+type FieldCost struct {
+	// Cost of resolving the field itself. Defaults to 1.
+	Cost int
+	// Multiplier applied to the complexity of the selections of the field.
+	// Defaults to the requested page size for fields with a first or limit
+	// argument, the default value of the argument if it is omitted,
+	// DefaultListSize for other lists and 1 for everything else.
+	Multiplier int
+}
+
+// Config holds the limits of a Checker. A limit of 0 disables the check.
+type Config struct {
+	MaxComplexity int
+	// DefaultListSize is the assumed number of items of a list field
+	DefaultListSize int
+	// FieldCosts holds the cost overrides keyed by Type.field
+	FieldCosts map[string]FieldCost
+}
+
+// Checker checks queries against the limits of its Config
+type Checker struct {
+	config Config
+	schema *graphql.Schema
+	info   *schemaInfo
+}
+
+// NewChecker creates a Checker for the schema
+func NewChecker(schema *graphql.Schema, config Config) *Checker {
+	if config.DefaultListSize <= 0 {
+		config.DefaultListSize = 1
+	}
+	return &Checker{config: config, schema: schema, info: newSchemaInfo(schema.ASTSchema())}
+}
+
+// Check returns descriptive errors if the operation is invalid or exceeds
+// one of the limits. The query is validated by graphql-go first, so it is
+// parsed the same way it is executed. graphql-go doesn't export the parsed
+// query, so the complexity is estimated on a parse of its own. Queries
+// which this parse rejects are rejected as well instead of passing
+// unchecked.
+func (c *Checker) Check(query, operationName string, variables map[string]interface{}) []*gqlerrors.QueryError {
+	if c == nil {
+		return nil
+	}
+
+	if errs := c.schema.ValidateWithVariables(query, variables); len(errs) > 0 {
+		return errs
+	}
+	if c.config.MaxComplexity <= 0 {
+		return nil
+	}
+	doc, err := parseQuery(query)
+	if err != nil {
+		return []*gqlerrors.QueryError{gqlerrors.Errorf("Unable to estimate the complexity of the query: %s", err)}
+	}
+
+	for _, op := range doc.operations {
+		if operationName != "" && op.name != operationName {
+			continue
+		}
+
+		a := &analysis{checker: c, doc: doc, variables: variables, visiting: make(map[string]bool)}
+		complexity := a.complexity(c.info.roots[op.kind], op.selections)
+		if complexity > c.config.MaxComplexity {
+			return []*gqlerrors.QueryError{gqlerrors.Errorf("Query complexity %d exceeds the maximum complexity of %d",
+				complexity, c.config.MaxComplexity)}
+		}
+	}
+	return nil
+}
+
+// analysis walks the selections of a single operation
+type analysis struct {
+	checker   *Checker
+	doc       *document
+	variables map[string]interface{}
+	// visiting guards against fragment cycles, graphql-go already rejected them
+	visiting map[string]bool
+}
+
+func (a *analysis) complexity(typeName string, selections []*selection) int {
+	total := 0
+	a.each(typeName, selections, func(typeName string, sel *selection) {
+		cost, multiplier := a.fieldCost(typeName, sel)
+		total += cost
+		if len(sel.selections) > 0 {
+			total += multiplier * a.complexity(a.fieldType(typeName, sel.name), sel.selections)
+		}
+	})
+	return total
+}
+
+// each calls fn for every field of the selections with fragments resolved.
+// Introspection fields are skipped so tools like GraphiQL keep working.
+func (a *analysis) each(typeName string, selections []*selection, fn func(typeName string, sel *selection)) {
+	for _, sel := range selections {
+		switch {
+		case sel.fragment != "":
+			frag, ok := a.doc.fragments[sel.fragment]
+			if !ok || a.visiting[sel.fragment] {
+				continue
+			}
+			a.visiting[sel.fragment] = true
+			a.each(frag.typeCondition, frag.selections, fn)
+			delete(a.visiting, sel.fragment)
+		case sel.inline:
+			t := typeName
+			if sel.typeCondition != "" {
+				t = sel.typeCondition
+			}
+			a.each(t, sel.selections, fn)
+		case strings.HasPrefix(sel.name, "__"):
+			continue
+		default:
+			fn(typeName, sel)
+		}
+	}
+}
+
+func (a *analysis) fieldType(typeName, fieldName string) string {
+	if field := a.field(typeName, fieldName); field != nil {
+		return field.typeName
+	}
+	return ""
+}
+
+func (a *analysis) field(typeName, fieldName string) *fieldDef {
+	if fields, ok := a.checker.info.types[typeName]; ok {
+		return fields[fieldName]
+	}
+	return nil
+}
+
+func (a *analysis) fieldCost(typeName string, sel *selection) (cost int, multiplier int) {
+	config := a.checker.config
+	override := config.FieldCosts[typeName+"."+sel.name]
+
+	cost = 1
+	if override.Cost > 0 {
+		cost = override.Cost
+	}
+	if override.Multiplier > 0 {
+		return cost, override.Multiplier
+	}
+
+	field := a.field(typeName, sel.name)
+	if field == nil {
+		return cost, 1
+	}
+	for _, arg := range []string{"first", "limit"} {
+		if !field.args[arg] {
+			continue
+		}
+		if n, ok := sel.args[arg].intValue(a.variables); ok && n > 0 {
+			return cost, n
+		}
+		if n := field.defaults[arg]; n > 0 {
+			return cost, n
+		}
+		return cost, config.DefaultListSize
+	}
+	if field.list {
+		return cost, config.DefaultListSize
+	}
+	return cost, 1
+}
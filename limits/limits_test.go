@@ -0,0 +1,53 @@
+package limits
+
+import (
+	"testing"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+const testSchema = `
+schema {
+  query: Query
+}
+
+type Query {
+  items(first: Int = 20): [Item!]!
+  tags: [String!]!
+}
+
+type Item {
+  name: String!
+}
+`
+
+func TestComplexityOfPagedLists(t *testing.T) {
+	checker := NewChecker(graphql.MustParseSchema(testSchema, nil), Config{
+		MaxComplexity:   30,
+		DefaultListSize: 10,
+	})
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		ok        bool
+	}{
+		// 1 + 5
+		{"requested page size", `{ items(first: 5) { name } }`, nil, true},
+		// 1 + 20, the default of the argument
+		{"default page size", `{ items { name } }`, nil, true},
+		// 1 + 20 + 1 + 10, it would be 22 with the default list size
+		{"default page size counted", `{ items { name } more: items(first: 10) { name } }`, nil, false},
+		// 1 + 50
+		{"page size from a variable", `query($n: Int) { items(first: $n) { name } }`, map[string]interface{}{"n": 50.0}, false},
+		// 1 + 20 + 1 + 10
+		{"omitted variable", `query($n: Int) { items(first: $n) { name } more: items(first: 10) { name } }`, nil, false},
+	}
+	for _, test := range tests {
+		errs := checker.Check(test.query, "", test.variables)
+		if ok := len(errs) == 0; ok != test.ok {
+			t.Errorf("%s: got errors %v, want ok %t", test.name, errs, test.ok)
+		}
+	}
+}
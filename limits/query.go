@@ -0,0 +1,294 @@
+package limits
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// selection is a field, a fragment spread or an inline fragment
+type selection struct {
+	name string
+	args map[string]value
+	// fragment is set for fragment spreads
+	fragment string
+	// inline is set for inline fragments
+	inline        bool
+	typeCondition string
+	selections    []*selection
+}
+
+// value of an argument. Only integers and variables are of interest.
+type value struct {
+	variable string
+	number   string
+}
+
+type operation struct {
+	kind       string
+	name       string
+	selections []*selection
+}
+
+type fragment struct {
+	typeCondition string
+	selections    []*selection
+}
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+// parseQuery parses an executable GraphQL document
+func parseQuery(query string) (*document, error) {
+	doc := &document{fragments: make(map[string]*fragment)}
+	l, err := newLexer(query)
+	if err != nil {
+		return nil, err
+	}
+
+	for l.token.kind != tokenEOF {
+		switch {
+		case l.peek("{"):
+			selections, err := parseSelectionSet(l)
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: selections})
+		case l.peek("query") || l.peek("mutation") || l.peek("subscription"):
+			op, err := parseOperation(l)
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case l.peek("fragment"):
+			if err := parseFragment(l, doc); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q", l.token.value)
+		}
+	}
+	return doc, nil
+}
+
+func parseOperation(l *lexer) (*operation, error) {
+	op := &operation{kind: l.token.value}
+	if err := l.next(); err != nil {
+		return nil, err
+	}
+	if l.token.kind == tokenName {
+		op.name = l.token.value
+		if err := l.next(); err != nil {
+			return nil, err
+		}
+	}
+	if l.peek("(") {
+		// Variable definitions
+		if err := l.skipBalanced("(", ")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := skipDirectives(l); err != nil {
+		return nil, err
+	}
+
+	selections, err := parseSelectionSet(l)
+	if err != nil {
+		return nil, err
+	}
+	op.selections = selections
+	return op, nil
+}
+
+func parseFragment(l *lexer, doc *document) error {
+	if err := l.expect("fragment"); err != nil {
+		return err
+	}
+	name, err := l.expectName()
+	if err != nil {
+		return err
+	}
+	if err := l.expect("on"); err != nil {
+		return err
+	}
+	typeCondition, err := l.expectName()
+	if err != nil {
+		return err
+	}
+	if err := skipDirectives(l); err != nil {
+		return err
+	}
+	selections, err := parseSelectionSet(l)
+	if err != nil {
+		return err
+	}
+	doc.fragments[name] = &fragment{typeCondition: typeCondition, selections: selections}
+	return nil
+}
+
+func parseSelectionSet(l *lexer) ([]*selection, error) {
+	if err := l.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var selections []*selection
+	for !l.peek("}") {
+		if l.token.kind == tokenEOF {
+			return nil, l.expect("}")
+		}
+		sel, err := parseSelection(l)
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	return selections, l.next()
+}
+
+func parseSelection(l *lexer) (*selection, error) {
+	if ok, err := l.skip("..."); err != nil || ok {
+		if err != nil {
+			return nil, err
+		}
+		return parseFragmentSelection(l)
+	}
+
+	name, err := l.expectName()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := l.skip(":"); err != nil || ok {
+		if err != nil {
+			return nil, err
+		}
+		// The name was an alias
+		if name, err = l.expectName(); err != nil {
+			return nil, err
+		}
+	}
+
+	sel := &selection{name: name, args: make(map[string]value)}
+	if l.peek("(") {
+		if err := parseArguments(l, sel); err != nil {
+			return nil, err
+		}
+	}
+	if err := skipDirectives(l); err != nil {
+		return nil, err
+	}
+	if l.peek("{") {
+		if sel.selections, err = parseSelectionSet(l); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+func parseFragmentSelection(l *lexer) (*selection, error) {
+	if l.token.kind == tokenName && !l.peek("on") {
+		name := l.token.value
+		if err := l.next(); err != nil {
+			return nil, err
+		}
+		return &selection{fragment: name}, skipDirectives(l)
+	}
+
+	sel := &selection{inline: true}
+	if ok, err := l.skip("on"); err != nil || ok {
+		if err != nil {
+			return nil, err
+		}
+		if sel.typeCondition, err = l.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	if err := skipDirectives(l); err != nil {
+		return nil, err
+	}
+	selections, err := parseSelectionSet(l)
+	if err != nil {
+		return nil, err
+	}
+	sel.selections = selections
+	return sel, nil
+}
+
+func parseArguments(l *lexer, sel *selection) error {
+	if err := l.expect("("); err != nil {
+		return err
+	}
+	for !l.peek(")") {
+		name, err := l.expectName()
+		if err != nil {
+			return err
+		}
+		if err := l.expect(":"); err != nil {
+			return err
+		}
+		v, err := parseValue(l)
+		if err != nil {
+			return err
+		}
+		sel.args[name] = v
+	}
+	return l.next()
+}
+
+func parseValue(l *lexer) (value, error) {
+	switch {
+	case l.peek("$"):
+		if err := l.next(); err != nil {
+			return value{}, err
+		}
+		name, err := l.expectName()
+		return value{variable: name}, err
+	case l.peek("["):
+		return value{}, l.skipBalanced("[", "]")
+	case l.peek("{"):
+		return value{}, l.skipBalanced("{", "}")
+	case l.token.kind == tokenNumber:
+		v := value{number: l.token.value}
+		return v, l.next()
+	case l.token.kind == tokenString || l.token.kind == tokenName:
+		return value{}, l.next()
+	}
+	return value{}, fmt.Errorf("unexpected %q", l.token.value)
+}
+
+func skipDirectives(l *lexer) error {
+	for l.peek("@") {
+		if err := l.next(); err != nil {
+			return err
+		}
+		if _, err := l.expectName(); err != nil {
+			return err
+		}
+		if l.peek("(") {
+			if err := l.skipBalanced("(", ")"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// intValue resolves an argument to an integer using the variables of the request
+func (v value) intValue(variables map[string]interface{}) (int, bool) {
+	if v.variable != "" {
+		switch n := variables[v.variable].(type) {
+		case float64:
+			return int(n), true
+		case int:
+			return n, true
+		case int32:
+			return int(n), true
+		}
+		return 0, false
+	}
+	if v.number != "" {
+		n, err := strconv.Atoi(v.number)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,78 @@
+package limits
+
+import "github.com/graph-gophers/graphql-go/types"
+
+// fieldDef is the part of a field definition needed to estimate costs
+type fieldDef struct {
+	// typeName is the named type of the field without list and non-null wrappers
+	typeName string
+	list     bool
+	args     map[string]bool
+	// defaults holds the default values of integer arguments
+	defaults map[string]int
+}
+
+// schemaInfo holds the fields of all object and interface types of a schema
+type schemaInfo struct {
+	types map[string]map[string]*fieldDef
+	roots map[string]string
+}
+
+// newSchemaInfo reads the fields of all object and interface types from the
+// schema as parsed by graphql-go. Other types are irrelevant for costs.
+func newSchemaInfo(schema *types.Schema) *schemaInfo {
+	info := &schemaInfo{
+		types: make(map[string]map[string]*fieldDef),
+		roots: make(map[string]string),
+	}
+	for operation, t := range schema.EntryPoints {
+		info.roots[operation] = t.TypeName()
+	}
+
+	for name, t := range schema.Types {
+		var fields types.FieldsDefinition
+		switch t := t.(type) {
+		case *types.ObjectTypeDefinition:
+			fields = t.Fields
+		case *types.InterfaceTypeDefinition:
+			fields = t.Fields
+		default:
+			continue
+		}
+
+		defs := make(map[string]*fieldDef)
+		for _, f := range fields {
+			field := &fieldDef{args: make(map[string]bool), defaults: make(map[string]int)}
+			for _, arg := range f.Arguments {
+				field.args[arg.Name.Name] = true
+				if arg.Default == nil {
+					continue
+				}
+				if n, ok := arg.Default.Deserialize(nil).(int32); ok {
+					field.defaults[arg.Name.Name] = int(n)
+				}
+			}
+			field.typeName, field.list = namedType(f.Type)
+			defs[f.Name] = field
+		}
+		info.types[name] = defs
+	}
+	return info
+}
+
+// namedType unwraps list and non-null types
+func namedType(t types.Type) (name string, list bool) {
+	for {
+		switch wrapped := t.(type) {
+		case *types.List:
+			list = true
+			t = wrapped.OfType
+		case *types.NonNull:
+			t = wrapped.OfType
+		case types.NamedType:
+			return wrapped.TypeName(), list
+		default:
+			return "", list
+		}
+	}
+}
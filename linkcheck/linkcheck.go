@@ -0,0 +1,202 @@
+// Package linkcheck checks whether URLs are still reachable. It sends HEAD
+// requests, so only the headers are transferred, and retries requests which
+// failed temporarily with an exponential backoff.
+package linkcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNotPublic is the error of links to loopback, private or link-local addresses
+var ErrNotPublic = errors.New("not a public address")
+
+// Result is the outcome of the check of a URL
+type Result struct {
+	URL string
+	// StatusCode is the status of the last response, 0 if there was none
+	StatusCode int
+	// Error describes why there was no response
+	Error     string
+	CheckedAt time.Time
+}
+
+// OK checks whether the URL was reachable. Redirects are followed,
+// so only the status of the final response counts.
+func (r *Result) OK() bool {
+	return r.Error == "" && r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Checker checks URLs concurrently. The Client can be replaced, e.g.
+// to check against a local test server.
+type Checker struct {
+	Client *http.Client
+	// Concurrency is the number of requests which run at the same time
+	Concurrency int
+	// Retries is how often a request is repeated after a network
+	// error, a server error or when the server is rate limiting
+	Retries int
+	// Backoff is the time before the first retry, it doubles with every retry
+	Backoff time.Duration
+	// MaxBackoff limits the time between retries and how long a
+	// Retry-After header of the server is respected
+	MaxBackoff time.Duration
+	UserAgent  string
+}
+
+// New returns a checker with defaults which are polite to other servers.
+// The URLs are set by users, so its client only connects to public
+// addresses, also when following redirects.
+func New(concurrency int) *Checker {
+	return &Checker{
+		Client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: dialPublic},
+		},
+		Concurrency: concurrency,
+		Retries:     3,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+		UserAgent:   "gamechars-server link checker",
+	}
+}
+
+// Check checks all URLs and returns the results in the same order. URLs
+// which weren't checked because the context was cancelled have an error.
+func (c *Checker) Check(ctx context.Context, urls []string) []Result {
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]Result, len(urls))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = c.check(ctx, urls[i])
+			}
+		}()
+	}
+	for i := range urls {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+// check requests a URL until it succeeds, fails permanently or the retries are used up
+func (c *Checker) check(ctx context.Context, url string) Result {
+	result := Result{URL: url}
+	wait := c.Backoff
+	for attempt := 0; ; attempt++ {
+		res, err := c.request(ctx, http.MethodHead, url)
+		// Some servers don't implement HEAD
+		if err == nil && (res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented) {
+			res, err = c.request(ctx, http.MethodGet, url)
+		}
+
+		result.CheckedAt = time.Now()
+		result.StatusCode = 0
+		result.Error = ""
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.StatusCode = res.StatusCode
+		}
+		if attempt >= c.Retries || ctx.Err() != nil || !retry(res, err) {
+			return result
+		}
+
+		delay := wait
+		if res != nil {
+			if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+				delay = time.Duration(seconds) * time.Second
+			}
+		}
+		if c.MaxBackoff > 0 && delay > c.MaxBackoff {
+			delay = c.MaxBackoff
+		}
+		wait *= 2
+
+		select {
+		case <-ctx.Done():
+			result.Error = ctx.Err().Error()
+			return result
+		case <-time.After(delay):
+		}
+	}
+}
+
+// request sends a request and discards the body of the response
+func (c *Checker) request(ctx context.Context, method string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+// retry decides whether a failed request might succeed later
+func retry(res *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrNotPublic)
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+// dialPublic connects to a host only if all of its addresses are public.
+// Otherwise users could let the server request its own or internal services.
+func dialPublic(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return nil, fmt.Errorf("%s is %w", host, ErrNotPublic)
+		}
+	}
+
+	// Connect to the checked addresses, the host could resolve differently now
+	var dialer net.Dialer
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// publicIP checks whether an address is reachable from the internet
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
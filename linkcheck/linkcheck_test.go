@@ -0,0 +1,263 @@
+package linkcheck
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testChecker checks against a local test server, New refuses loopback addresses
+func testChecker(server *httptest.Server) *Checker {
+	c := New(1)
+	c.Client = server.Client()
+	c.Backoff = 10 * time.Millisecond
+	return c
+}
+
+// responses answers the requests with the given statuses in order,
+// the last one repeatedly. The returned function counts the requests.
+func responses(statuses ...int) (http.HandlerFunc, func() int) {
+	var mu sync.Mutex
+	requests := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		status := statuses[len(statuses)-1]
+		if requests < len(statuses) {
+			status = statuses[requests]
+		}
+		requests++
+		mu.Unlock()
+		w.WriteHeader(status)
+	}
+	return handler, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestServerErrorThenOK(t *testing.T) {
+	handler, requests := responses(http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	c := testChecker(server)
+	start := time.Now()
+	res := c.Check(context.Background(), []string{server.URL})[0]
+	if !res.OK() || res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, error %q, want 200", res.StatusCode, res.Error)
+	}
+	if requests() != 3 {
+		t.Errorf("got %d requests, want 3", requests())
+	}
+	// The backoff doubles, 10ms before the first and 20ms before the second retry
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("retried after %s, want at least 30ms", elapsed)
+	}
+}
+
+func TestRetriesUsedUp(t *testing.T) {
+	handler, requests := responses(http.StatusInternalServerError)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	c := testChecker(server)
+	c.Retries = 2
+	res := c.Check(context.Background(), []string{server.URL})[0]
+	if res.OK() || res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", res.StatusCode)
+	}
+	if requests() != 3 {
+		t.Errorf("got %d requests, want 3", requests())
+	}
+}
+
+func TestNotFoundIsNotRetried(t *testing.T) {
+	handler, requests := responses(http.StatusNotFound)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	res := testChecker(server).Check(context.Background(), []string{server.URL})[0]
+	if res.OK() || res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", res.StatusCode)
+	}
+	if requests() != 1 {
+		t.Errorf("got %d requests, want 1", requests())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		retryAfter string
+		maxBackoff time.Duration
+		min, max   time.Duration
+	}{
+		{"respected", "1", time.Minute, time.Second, 5 * time.Second},
+		{"limited by MaxBackoff", "3600", 50 * time.Millisecond, 50 * time.Millisecond, 5 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, _ := responses(http.StatusTooManyRequests, http.StatusOK)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", tc.retryAfter)
+				handler(w, r)
+			}))
+			defer server.Close()
+
+			c := testChecker(server)
+			c.Backoff = time.Millisecond
+			c.MaxBackoff = tc.maxBackoff
+			start := time.Now()
+			res := c.Check(context.Background(), []string{server.URL})[0]
+			elapsed := time.Since(start)
+			if !res.OK() {
+				t.Errorf("got status %d, error %q, want 200", res.StatusCode, res.Error)
+			}
+			if elapsed < tc.min || elapsed > tc.max {
+				t.Errorf("retried after %s, want between %s and %s", elapsed, tc.min, tc.max)
+			}
+		})
+	}
+}
+
+func TestHeadNotAllowed(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	res := testChecker(server).Check(context.Background(), []string{server.URL})[0]
+	if !res.OK() {
+		t.Errorf("got status %d, error %q, want 200", res.StatusCode, res.Error)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) != 2 || methods[0] != http.MethodHead || methods[1] != http.MethodGet {
+		t.Errorf("got requests %v, want [HEAD GET]", methods)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	const concurrency = 3
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	c := testChecker(server)
+	c.Concurrency = concurrency
+	var urls []string
+	for i := 0; i < 12; i++ {
+		urls = append(urls, server.URL+"?status="+strconv.Itoa(200+i))
+	}
+	results := c.Check(context.Background(), urls)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning > concurrency {
+		t.Errorf("%d requests ran at the same time, want at most %d", maxRunning, concurrency)
+	}
+	for i, res := range results {
+		if res.URL != urls[i] || res.StatusCode != 200+i {
+			t.Errorf("result %d is %s with status %d, want %s with status %d", i, res.URL, res.StatusCode, urls[i], 200+i)
+		}
+	}
+}
+
+func TestNonPublicAddressRefused(t *testing.T) {
+	handler, requests := responses(http.StatusOK)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// Refused addresses aren't retried
+	c := New(1)
+	c.Backoff = time.Hour
+	for _, url := range []string{server.URL, "http://localhost:" + port} {
+		res := c.Check(context.Background(), []string{url})[0]
+		if res.OK() || res.StatusCode != 0 || !strings.Contains(res.Error, ErrNotPublic.Error()) {
+			t.Errorf("%s got status %d, error %q, want it refused", url, res.StatusCode, res.Error)
+		}
+	}
+	if requests() != 0 {
+		t.Errorf("got %d requests, want none", requests())
+	}
+}
+
+func TestRedirectToNonPublicAddressRefused(t *testing.T) {
+	handler, requests := responses(http.StatusOK)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(server.URL, http.StatusFound))
+	defer redirect.Close()
+
+	// Pretend the redirecting server is public
+	c := New(1)
+	c.Retries = 0
+	c.Client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			if address == redirect.Listener.Addr().String() {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			}
+			return dialPublic(ctx, network, address)
+		},
+	}
+
+	res := c.Check(context.Background(), []string{redirect.URL})[0]
+	if res.OK() || !strings.Contains(res.Error, ErrNotPublic.Error()) {
+		t.Errorf("got status %d, error %q, want the redirect refused", res.StatusCode, res.Error)
+	}
+	if requests() != 0 {
+		t.Errorf("got %d requests, want none", requests())
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+	} {
+		if got := publicIP(net.ParseIP(ip)); got != public {
+			t.Errorf("publicIP(%s) = %v, want %v", ip, got, public)
+		}
+	}
+}
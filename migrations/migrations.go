@@ -0,0 +1,102 @@
+// Package migrations keeps the stored records in line with the code. The
+// records are JSON blobs without a version, so every change to their format
+// needs a migration which converts the existing records.
+//
+// The database stores the number of migrations applied to it in the Meta
+// bucket. Migrations run in order, each in its own transaction together with
+// the update of that number, so a failing migration leaves the database at
+// the last successful one.
+package migrations
+
+import (
+	"encoding/binary"
+
+	"github.com/coreos/bbolt"
+	"github.com/pkg/errors"
+)
+
+const bucketName = "Meta"
+
+var versionKey = []byte("schemaVersion")
+
+// Migration converts the database from the previous version. Migrations
+// should be idempotent, as databases which were changed by hand before the
+// migration existed might already be in the new format.
+type Migration struct {
+	Name string
+	Up   func(tx *bolt.Tx) error
+}
+
+// Version reads the number of migrations applied to the database
+func Version(db *bolt.DB) (int, error) {
+	var version int
+	err := db.View(func(tx *bolt.Tx) error {
+		version = readVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+func readVersion(tx *bolt.Tx) int {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return 0
+	}
+	v := b.Get(versionKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func putVersion(tx *bolt.Tx, version int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(version))
+	return b.Put(versionKey, v)
+}
+
+// Check returns the number of pending migrations. It fails if the database
+// was migrated by a newer binary, which knows migrations this one doesn't.
+func Check(db *bolt.DB, migrations []Migration) (int, error) {
+	version, err := Version(db)
+	if err != nil {
+		return 0, err
+	}
+	if version > len(migrations) {
+		return 0, errors.Errorf("The database is at version %d, but this binary only knows versions up to %d", version, len(migrations))
+	}
+	return len(migrations) - version, nil
+}
+
+// Run applies all pending migrations in order and returns their names
+func Run(db *bolt.DB, migrations []Migration) ([]string, error) {
+	if _, err := Check(db, migrations); err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for {
+		var name string
+		err := db.Update(func(tx *bolt.Tx) error {
+			version := readVersion(tx)
+			if version >= len(migrations) {
+				return nil
+			}
+
+			m := migrations[version]
+			if err := m.Up(tx); err != nil {
+				return errors.Wrapf(err, "Migration %d (%s) failed", version+1, m.Name)
+			}
+			name = m.Name
+			return putVersion(tx, version+1)
+		})
+		if err != nil || name == "" {
+			return applied, err
+		}
+		applied = append(applied, name)
+	}
+}
@@ -0,0 +1,265 @@
+// Package persisted implements persisted queries. Clients send the sha256
+// hash of a query instead of the query itself. Unknown hashes are registered
+// on the next request which contains the full query, as described by
+// Apollo's automatic persisted queries:
+// https://www.apollographql.com/docs/apollo-server/performance/apq/
+//
+// Registered queries are limited in size and number. Once there are too
+// many, the oldest registrations are evicted. Queries of a manifest are
+// kept apart from the ones registered by clients and are never evicted.
+//
+// In allowlist mode only the queries of a manifest generated at build time
+// of the frontend are executed.
+package persisted
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/coreos/bbolt"
+	"github.com/pkg/errors"
+)
+
+// bucketName holds the queries registered by clients
+const bucketName = "PersistedQueries"
+
+// manifestBucketName holds the queries of the manifest
+const manifestBucketName = "PersistedManifestQueries"
+
+// orderBucketName maps the sequence number of a registration to the hash of
+// the query, so the oldest registrations can be evicted
+const orderBucketName = "PersistedQueriesOrder"
+
+// Defaults of the limits of a Store
+const (
+	DefaultMaxQuerySize = 32 << 10
+	DefaultMaxQueries   = 10000
+)
+
+// Error messages and codes as expected by apollo-link-persisted-queries
+const (
+	NotFoundMessage = "PersistedQueryNotFound"
+	NotFoundCode    = "PERSISTED_QUERY_NOT_FOUND"
+)
+
+// ErrNotFound tells the client to send the full query along with the hash
+var ErrNotFound = errors.New(NotFoundMessage)
+
+// ErrNotAllowed is returned in allowlist mode for queries which are not in the manifest
+var ErrNotAllowed = errors.New("Operation is not in the allowlist of persisted queries")
+
+// ErrTooLarge is returned by Register for queries exceeding MaxQuerySize
+var ErrTooLarge = errors.New("Query is too large to be persisted")
+
+// Extension is the persistedQuery entry of the extensions of a request
+type Extension struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+// Store keeps the persisted queries of clients in the PersistedQueries
+// bucket and the ones of the manifest in the PersistedManifestQueries bucket
+type Store struct {
+	db        *bolt.DB
+	allowlist bool
+	// MaxQuerySize is the maximum size in bytes of a query clients can register
+	MaxQuerySize int
+	// MaxQueries is the maximum number of queries registered by clients
+	MaxQueries int
+}
+
+// NewStore creates a Store with the default limits. With allowlist set only
+// queries of the manifest are executed and clients can't register new ones.
+func NewStore(db *bolt.DB, allowlist bool) *Store {
+	return &Store{
+		db:           db,
+		allowlist:    allowlist,
+		MaxQuerySize: DefaultMaxQuerySize,
+		MaxQueries:   DefaultMaxQueries,
+	}
+}
+
+// Hash returns the hex encoded sha256 hash of a query
+func Hash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// get looks the hash up in the manifest and, unless the store is in
+// allowlist mode, in the queries registered by clients
+func (s *Store) get(hash string) (string, error) {
+	var query string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		query = string(tx.Bucket([]byte(manifestBucketName)).Get([]byte(hash)))
+		if query == "" && !s.allowlist {
+			query = string(tx.Bucket([]byte(bucketName)).Get([]byte(hash)))
+		}
+		return nil
+	})
+	return query, err
+}
+
+// Register stores a query of a client under its hash. The oldest queries
+// registered by clients are evicted if there are more than MaxQueries.
+func (s *Store) Register(query string) error {
+	if s.MaxQuerySize > 0 && len(query) > s.MaxQuerySize {
+		return ErrTooLarge
+	}
+
+	hash := []byte(Hash(query))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b.Get(hash) != nil || tx.Bucket([]byte(manifestBucketName)).Get(hash) != nil {
+			return nil
+		}
+		if err := b.Put(hash, []byte(query)); err != nil {
+			return err
+		}
+
+		order := tx.Bucket([]byte(orderBucketName))
+		seq, err := order.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := order.Put(sequenceKey(seq), hash); err != nil {
+			return err
+		}
+		return s.evict(b, order)
+	})
+}
+
+// evict deletes the oldest registrations until at most MaxQueries are left.
+// Registrations which moved to the manifest leave gaps in the sequence
+// numbers, so the remaining ones are counted.
+func (s *Store) evict(b *bolt.Bucket, order *bolt.Bucket) error {
+	if s.MaxQueries <= 0 {
+		return nil
+	}
+
+	count := 0
+	c := order.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		count++
+	}
+
+	for k, hash := c.First(); k != nil && count > s.MaxQueries; k, hash = c.First() {
+		if err := b.Delete(hash); err != nil {
+			return err
+		}
+		if err := order.Delete(k); err != nil {
+			return err
+		}
+		count--
+	}
+	return nil
+}
+
+func sequenceKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// Resolve returns the query to execute for a request. ext may be nil if
+// the client did not send a persisted query extension. unknown is set if
+// the client sent a query with a hash which isn't registered yet. Call
+// Register once the query is known to be valid to persist it.
+func (s *Store) Resolve(query string, ext *Extension) (resolved string, unknown bool, err error) {
+	if ext == nil || ext.Sha256Hash == "" {
+		if !s.allowlist {
+			return query, false, nil
+		}
+		// Clients which don't know about persisted queries may still
+		// send queries of the allowlist in full
+		ext = &Extension{Sha256Hash: Hash(query)}
+	}
+
+	if query != "" && Hash(query) != ext.Sha256Hash {
+		return "", false, errors.New("Provided sha256Hash does not match the query")
+	}
+
+	stored, err := s.get(ext.Sha256Hash)
+	if err != nil {
+		return "", false, err
+	}
+	switch {
+	case stored != "":
+		return stored, false, nil
+	case s.allowlist:
+		return "", false, ErrNotAllowed
+	case query == "":
+		return "", false, ErrNotFound
+	}
+	return query, true, nil
+}
+
+// manifest is the format of the persisted query manifest generated by
+// @apollo/generate-persisted-query-manifest
+type manifest struct {
+	Operations []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Body string `json:"body"`
+	} `json:"operations"`
+}
+
+// LoadManifest stores all operations of a manifest file in one transaction
+// and returns the number of operations. Operations a client registered
+// before are removed from the client registrations, so they can't be evicted.
+func (s *Store) LoadManifest(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to read persisted query manifest")
+	}
+
+	var m manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return 0, errors.Wrap(err, "Unable to decode persisted query manifest")
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(manifestBucketName))
+		hashes := make(map[string]bool)
+		for _, op := range m.Operations {
+			hash := Hash(op.Body)
+			if op.ID != "" && op.ID != hash {
+				return errors.Errorf("The id of operation %s is not the sha256 hash of its body", op.Name)
+			}
+			if err := b.Put([]byte(hash), []byte(op.Body)); err != nil {
+				return err
+			}
+			hashes[hash] = true
+		}
+		return forgetRegistrations(tx, hashes)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(m.Operations), nil
+}
+
+// forgetRegistrations deletes the given hashes from the queries registered by clients
+func forgetRegistrations(tx *bolt.Tx, hashes map[string]bool) error {
+	b := tx.Bucket([]byte(bucketName))
+	order := tx.Bucket([]byte(orderBucketName))
+	var keys [][]byte
+	order.ForEach(func(k, hash []byte) error {
+		if hashes[string(hash)] {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+
+	for _, k := range keys {
+		if err := b.Delete(order.Get(k)); err != nil {
+			return err
+		}
+		if err := order.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
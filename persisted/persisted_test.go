@@ -0,0 +1,112 @@
+package persisted
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/bbolt"
+)
+
+const (
+	manifestQuery = "{ games { title } }"
+	clientQuery   = "{ tags { name } }"
+)
+
+// openTestStore opens a store on an empty database with the buckets the server creates
+func openTestStore(t *testing.T, allowlist bool) *Store {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "persisted.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{bucketName, orderBucketName, manifestBucketName} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(db, allowlist)
+}
+
+// loadManifest writes a manifest with the queries and loads it
+func loadManifest(t *testing.T, s *Store, queries ...string) {
+	content := `{"format":"apollo-persisted-query-manifest","version":1,"operations":[`
+	for i, query := range queries {
+		if i > 0 {
+			content += ","
+		}
+		content += fmt.Sprintf(`{"id":%q,"name":"Op%d","type":"query","body":%q}`, Hash(query), i, query)
+	}
+	content += "]}"
+
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadManifest(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAllowlistOnlyRunsManifestQueries registers a query as a client in
+// normal mode and checks that it doesn't run once the allowlist is enabled
+func TestAllowlistOnlyRunsManifestQueries(t *testing.T) {
+	s := openTestStore(t, false)
+	loadManifest(t, s, manifestQuery)
+	if err := s.Register(clientQuery); err != nil {
+		t.Fatal(err)
+	}
+	if query, _, err := s.Resolve("", &Extension{Version: 1, Sha256Hash: Hash(clientQuery)}); err != nil || query != clientQuery {
+		t.Fatalf("got %q, %v for the client query without allowlist", query, err)
+	}
+
+	allowlist := NewStore(s.db, true)
+	if _, _, err := allowlist.Resolve("", &Extension{Version: 1, Sha256Hash: Hash(clientQuery)}); err != ErrNotAllowed {
+		t.Errorf("got %v for the hash of the client query, want ErrNotAllowed", err)
+	}
+	if _, _, err := allowlist.Resolve(clientQuery, nil); err != ErrNotAllowed {
+		t.Errorf("got %v for the full client query, want ErrNotAllowed", err)
+	}
+	if query, _, err := allowlist.Resolve("", &Extension{Version: 1, Sha256Hash: Hash(manifestQuery)}); err != nil || query != manifestQuery {
+		t.Errorf("got %q, %v for the manifest query", query, err)
+	}
+}
+
+// TestManifestQueriesAreNotEvicted registers a query as a client before it
+// is added to the manifest and checks that it survives the eviction of all
+// client registrations
+func TestManifestQueriesAreNotEvicted(t *testing.T) {
+	s := openTestStore(t, false)
+	s.MaxQueries = 2
+	if err := s.Register(manifestQuery); err != nil {
+		t.Fatal(err)
+	}
+	loadManifest(t, s, manifestQuery)
+
+	for i := 0; i < 5; i++ {
+		if err := s.Register(fmt.Sprintf("{ character(id: %d) { name } }", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if query, _, err := s.Resolve("", &Extension{Version: 1, Sha256Hash: Hash(manifestQuery)}); err != nil || query != manifestQuery {
+		t.Errorf("got %q, %v for the manifest query", query, err)
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte(bucketName)).Stats().KeyN; n != 2 {
+			t.Errorf("got %d client registrations, want 2", n)
+		}
+		if n := tx.Bucket([]byte(orderBucketName)).Stats().KeyN; n != 2 {
+			t.Errorf("got %d entries in the order of the registrations, want 2", n)
+		}
+		return nil
+	})
+}
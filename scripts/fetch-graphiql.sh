@@ -0,0 +1,16 @@
+#!/bin/sh
+# Downloads the GraphiQL assets which are served by the server on /graphiql.
+# The versions are pinned, so the explorer doesn't change unexpectedly.
+set -e
+
+cd "$(dirname "$0")/.."
+mkdir -p static/graphiql
+cd static/graphiql
+
+curl -fsSLo graphiql.css https://cdnjs.cloudflare.com/ajax/libs/graphiql/0.11.5/graphiql.css
+curl -fsSLo graphiql.js https://cdnjs.cloudflare.com/ajax/libs/graphiql/0.11.5/graphiql.js
+curl -fsSLo fetch.min.js https://cdnjs.cloudflare.com/ajax/libs/fetch/1.1.0/fetch.min.js
+curl -fsSLo react.min.js https://cdnjs.cloudflare.com/ajax/libs/react/15.5.4/react.min.js
+curl -fsSLo react-dom.min.js https://cdnjs.cloudflare.com/ajax/libs/react/15.5.4/react-dom.min.js
+
+echo "GraphiQL assets downloaded to $(pwd)"
@@ -1,22 +1,32 @@
 package main
 
 import (
+	"crypto/subtle"
+	"embed"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	gorillaContext "github.com/gorilla/context"
 
 	"github.com/gorilla/sessions"
 
 	"github.com/coreos/bbolt"
+	"github.com/fusion44/gamechars-server/backup"
 	"github.com/fusion44/gamechars-server/data"
+	"github.com/fusion44/gamechars-server/limits"
+	"github.com/fusion44/gamechars-server/linkcheck"
+	"github.com/fusion44/gamechars-server/migrations"
+	"github.com/fusion44/gamechars-server/persisted"
 	"github.com/fusion44/gamechars-server/utils"
-	"github.com/neelance/graphql-go"
-	"github.com/neelance/graphql-go/relay"
-	"github.com/nicksrandall/batched-graphql-handler"
+	"github.com/graph-gophers/graphql-go"
 	"github.com/pkg/errors"
 	"github.com/rs/cors"
 	"github.com/rs/xid"
@@ -25,18 +35,79 @@ import (
 )
 
 var schema *graphql.Schema
+
+// staticFiles holds the GraphiQL page and its assets
+//
+//go:embed static
+var staticFiles embed.FS
+
+// graphiqlAssetFiles are the assets loaded by static/graphiql.html
+var graphiqlAssetFiles = []string{"graphiql.css", "graphiql.js", "fetch.min.js", "react.min.js", "react-dom.min.js"}
+
+// db is opened once in main and shared by all handlers and resolvers.
+// BoltDB locks the file, so it can't be opened more than once at a time.
+var db *bolt.DB
+
 var store = sessions.NewCookieStore([]byte("something-very-secret"))
 var validate *validator.Validate
 
+var trashRetention = flag.Duration("trash-retention", 30*24*time.Hour,
+	"how long removed characters are kept in the trash before they are purged")
+var moderators = flag.String("moderators", "",
+	"comma separated list of users which are allowed to delete any comment")
+var persistedQueriesOnly = flag.Bool("persisted-queries-only", false,
+	"only execute the queries of the persisted query manifest")
+var persistedQueriesManifest = flag.String("persisted-queries-manifest", "",
+	"JSON manifest of persisted queries to register at startup")
+var persistedQueriesMaxSize = flag.Int("persisted-queries-max-size", persisted.DefaultMaxQuerySize,
+	"maximum size in bytes of a query clients can persist, 0 disables the limit")
+var persistedQueriesMax = flag.Int("persisted-queries-max", persisted.DefaultMaxQueries,
+	"maximum number of queries persisted by clients before the oldest are evicted, 0 disables the limit")
+var graphiqlEnabled = flag.Bool("graphiql", true,
+	"serve the GraphiQL explorer on /graphiql, should be disabled in production")
+var introspectionEnabled = flag.Bool("introspection", true,
+	"allow schema introspection queries, GraphiQL requires them")
+var maxQueryDepth = flag.Int("max-query-depth", 10,
+	"maximum nesting depth of a GraphQL query, 0 disables the limit")
+var maxQueryComplexity = flag.Int("max-query-complexity", 5000,
+	"maximum estimated complexity of a GraphQL query, 0 disables the limit")
+var maxBatchSize = flag.Int("max-batch-size", 10,
+	"maximum number of operations in a batch request, 0 disables the limit")
+var maxRequestSize = flag.Int64("max-request-size", 4<<20,
+	"maximum size in bytes of the body of a GraphQL request, 0 disables the limit")
+var requestTimeout = flag.Duration("request-timeout", 10*time.Second,
+	"time after which a GraphQL request is canceled, 0 disables the timeout")
+var migrateOnStart = flag.Bool("migrate", true,
+	"apply pending database migrations at startup, otherwise the server refuses to start with pending migrations")
+var admins = flag.String("admins", "",
+	"comma separated list of users which are allowed to use the admin endpoints")
+var adminToken = flag.String("admin-token", os.Getenv("GAMECHARS_ADMIN_TOKEN"),
+	"token which authorizes requests to the admin endpoints, e.g. of the backup command, empty disables it")
+var backupDir = flag.String("backup-dir", "",
+	"directory for scheduled backups of the database, empty disables them")
+var backupInterval = flag.Duration("backup-interval", 24*time.Hour,
+	"time between two scheduled backups")
+var backupKeep = flag.Int("backup-keep", 7,
+	"number of scheduled backups to keep, older ones are deleted")
+var linkCheckInterval = flag.Duration("link-check-interval", 24*time.Hour,
+	"time between two checks of the wiki and image links of all characters, 0 disables the checks")
+var linkCheckConcurrency = flag.Int("link-check-concurrency", 4,
+	"number of links which are checked at the same time")
+
+// allowedOrigins are the origins of the frontend
+var allowedOrigins = []string{"http://localhost:3000"}
+
+const databaseFile = "gamechars.db"
 const cookieName = "gamechars-session"
 const userOpSuccessMsg = "OK"
+const trashPurgeInterval = time.Hour
 
-func init() {
-	gameCharacterSchema, err := ioutil.ReadFile("./data/gamecharacters.gql")
-	check(err)
+// databaseOpenTimeout is how long opening the database waits for the file
+// lock, which a running server holds
+const databaseOpenTimeout = time.Second
 
-	schema = graphql.MustParseSchema(string(gameCharacterSchema), &data.Resolver{})
-}
+// defaultListSize is the assumed length of lists when estimating the complexity of a query
+const defaultListSize = 10
 
 func check(e error) {
 	if e != nil {
@@ -101,18 +172,10 @@ func signUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Open BoltDB
-	db, err := bolt.Open("gamechars.db", 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	// Close the DB when this function returns
-	defer db.Close()
-
 	decoder := json.NewDecoder(r.Body)
 	var uinput userInput
 	check(decoder.Decode(&uinput))
-	err = validate.Struct(uinput)
+	err := validate.Struct(uinput)
 	defer r.Body.Close()
 
 	if err != nil {
@@ -184,18 +247,10 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Open BoltDB
-	db, err := bolt.Open("gamechars.db", 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	// Close the DB when this function returns
-	defer db.Close()
-
 	decoder := json.NewDecoder(r.Body)
 	var uinput userInput
 	check(decoder.Decode(&uinput))
-	err = validate.Struct(uinput)
+	err := validate.Struct(uinput)
 	defer r.Body.Close()
 
 	db.View(func(tx *bolt.Tx) error {
@@ -237,14 +292,6 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Open BoltDB
-	db, err := bolt.Open("gamechars.db", 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	// Close the DB when this function returns
-	defer db.Close()
-
 	session, err := store.Get(r, cookieName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -289,17 +336,269 @@ func authHandler(next http.Handler) http.Handler {
 				userName = session.Values["userName"].(string)
 			}
 
-			next.ServeHTTP(w, r.WithContext(utils.PutContextAuthData(ctx, auth, userName)))
+			// Lookups of the resolvers are batched and cached for the whole request
+			ctx = data.WithLoaders(utils.PutContextAuthData(ctx, auth, userName))
+			next.ServeHTTP(w, r.WithContext(ctx))
 		}
 	})
 }
 
+// adminHandler only passes requests of admins or with the admin token on
+// to next. It must be wrapped by the authHandler.
+func adminHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, err := utils.GetContextAuthData(r.Context())
+		if (err != nil || !data.IsAdmin(auth)) && !hasAdminToken(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasAdminToken checks whether the request is authorized with the admin token
+func hasAdminToken(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return *adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) == 1
+}
+
+// exportHandler streams the characters visible to the logged in user.
+// The query parameter format selects json, csv or jsonld, with own=true
+// only the characters of the user are exported.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := utils.GetContextAuthData(r.Context())
+	if err != nil || !auth.Authenticated {
+		http.Error(w, "Only logged in users can export characters", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = data.ExportJSON
+	}
+	contentType, ok := data.ExportContentType(format)
+	if !ok {
+		http.Error(w, "The format must be json, csv or jsonld", http.StatusBadRequest)
+		return
+	}
+	own, _ := strconv.ParseBool(r.URL.Query().Get("own"))
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"gamechars.%s\"", format))
+	// The status is already sent once streaming started, so errors can only be logged
+	if err := data.ExportCharacters(w, format, auth, own); err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+// graphiqlAssets returns the embedded assets of GraphiQL. They are downloaded
+// by scripts/fetch-graphiql.sh and not part of the repository, so a binary
+// built without them would serve an empty page.
+func graphiqlAssets() (fs.FS, error) {
+	assets, err := fs.Sub(staticFiles, "static/graphiql")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range graphiqlAssetFiles {
+		if _, err := fs.Stat(assets, name); err != nil {
+			return nil, errors.Errorf("The GraphiQL asset %s is missing, run scripts/fetch-graphiql.sh "+
+				"before building or start the server with -graphiql=false", name)
+		}
+	}
+	return assets, nil
+}
+
+// parseSchema parses the schema and checks that there is a resolver
+// method for every field, so mistakes are found at startup
+func parseSchema() (*graphql.Schema, error) {
+	var opts []graphql.SchemaOpt
+	if !*introspectionEnabled {
+		opts = append(opts, graphql.DisableIntrospection())
+	}
+	if *maxQueryDepth > 0 {
+		opts = append(opts, graphql.MaxDepth(*maxQueryDepth))
+	}
+
+	s, err := graphql.ParseSchema(data.Schema, &data.Resolver{}, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "The resolvers don't match the GraphQL schema")
+	}
+	return s, nil
+}
+
+// migrateDatabase applies the pending migrations to the database. If apply
+// is false, pending migrations are an error, so they can be applied with
+// the migrate command after taking a backup.
+func migrateDatabase(apply bool) error {
+	pending, err := migrations.Check(db, data.Migrations)
+	if err != nil {
+		return err
+	}
+	if pending > 0 && !apply {
+		return errors.Errorf("The database has %d pending migrations, run the migrate command first", pending)
+	}
+
+	applied, err := migrations.Run(db, data.Migrations)
+	for _, name := range applied {
+		fmt.Printf("Applied migration: %s\n", name)
+	}
+	return err
+}
+
+// backupDatabase writes a backup of the database to a file. The backup is
+// downloaded from the running server. Only if it isn't running, the
+// database file is read directly.
+func backupDatabase(args []string) error {
+	backupFlags := flag.NewFlagSet("backup", flag.ExitOnError)
+	server := backupFlags.String("server", "http://localhost:8080", "URL of the running server")
+	token := backupFlags.String("token", os.Getenv("GAMECHARS_ADMIN_TOKEN"), "admin token of the running server")
+	backupFlags.Parse(args)
+	if backupFlags.NArg() != 1 {
+		return errors.New("Usage: backup [-server url] [-token token] <file>")
+	}
+	path := backupFlags.Arg(0)
+
+	err := backup.Download(*server, *token, path)
+	if err != backup.ErrServerNotRunning {
+		return err
+	}
+
+	db, err := backup.OpenReadOnly(databaseFile)
+	if err != nil {
+		return errors.Wrap(err, "The server is not reachable and the database can't be opened")
+	}
+	defer db.Close()
+	return backup.WriteFile(db, path)
+}
+
+// restoreDatabase replaces the database with a backup. Backups of a newer
+// version of the server are refused like the database itself would be.
+func restoreDatabase(path string) error {
+	return backup.Restore(path, databaseFile, func(db *bolt.DB) error {
+		_, err := migrations.Check(db, data.Migrations)
+		return err
+	})
+}
+
+// importFile imports characters from a JSON or CSV file, the format is
+// determined by the file extension. See the ImportFormat in the schema.
+func importFile(args []string) error {
+	importFlags := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := importFlags.Bool("dry-run", false, "only validate the characters")
+	owner := importFlags.String("owner", "", "owner of the characters which don't have one")
+	importFlags.Parse(args)
+	if importFlags.NArg() != 1 {
+		return errors.New("Usage: import [-dry-run] [-owner name] <file.json|file.csv>")
+	}
+
+	path := importFlags.Arg(0)
+	format := data.ImportJSON
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		format = data.ImportCSV
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := data.ImportCharacters(f, format, *owner, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, e := range res.Errors {
+		fmt.Printf("Row %d: %s\n", e.Row, e.Message)
+	}
+	switch {
+	case len(res.Errors) > 0:
+		return errors.Errorf("%d rows are invalid, nothing was imported", len(res.Errors))
+	case res.DryRun:
+		fmt.Printf("All %d characters are valid.\n", res.Valid)
+	default:
+		fmt.Printf("Imported %d characters.\n", res.Imported)
+	}
+	return nil
+}
+
+// importWiki creates or updates characters from the infoboxes of
+// articles in a MediaWiki XML dump
+func importWiki(args []string) error {
+	wikiFlags := flag.NewFlagSet("import-wiki", flag.ExitOnError)
+	category := wikiFlags.String("category", "", "import all articles in this category")
+	owner := wikiFlags.String("owner", "", "owner of the imported characters")
+	public := wikiFlags.Bool("public", true, "make new characters public")
+	dryRun := wikiFlags.Bool("dry-run", false, "only show what would be imported")
+	wikiFlags.Parse(args)
+	if wikiFlags.NArg() < 1 || (wikiFlags.NArg() == 1 && *category == "") {
+		return errors.New("Usage: import-wiki [-category name] -owner name [-public=false] [-dry-run] <dump.xml> [titles...]")
+	}
+
+	f, err := os.Open(wikiFlags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := data.ImportWikiPages(f, wikiFlags.Args()[1:], *category, *owner, *public, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, title := range res.Missing {
+		fmt.Printf("Not in the dump: %s\n", title)
+	}
+	for _, title := range res.Skipped {
+		fmt.Printf("No infobox: %s\n", title)
+	}
+	if res.DryRun {
+		fmt.Printf("Would create %d and update %d characters.\n", res.Created, res.Updated)
+	} else {
+		fmt.Printf("Created %d and updated %d characters.\n", res.Created, res.Updated)
+	}
+	return nil
+}
+
 func main() {
-	db, err := bolt.Open("gamechars.db", 0600, nil)
+	flag.Parse()
+
+	var err error
+	schema, err = parseSchema()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	switch flag.Arg(0) {
+	case "schema":
+		// Print the schema compiled into the binary
+		fmt.Print(data.Schema)
+		return
+	case "backup":
+		if err := backupDatabase(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "restore":
+		if flag.NArg() != 2 {
+			log.Fatalf("Usage: %s <file>", flag.Arg(0))
+		}
+		if err := restoreDatabase(flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "", "serve", "migrate", "import", "import-wiki":
+	default:
+		log.Fatalf("Unknown command %q, the commands are serve, migrate, import, import-wiki, backup, restore and schema", flag.Arg(0))
+	}
+
+	db, err = bolt.Open(databaseFile, 0600, &bolt.Options{Timeout: databaseOpenTimeout})
+	if err == bolt.ErrTimeout {
+		log.Fatal(errors.Wrap(err, "The database is in use, stop the server first"))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
 	db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte("Users"))
 		if err != nil {
@@ -311,24 +610,124 @@ func main() {
 			return fmt.Errorf("create game characters bucket: %s", err)
 		}
 
+		_, err = tx.CreateBucketIfNotExists([]byte("Games"))
+		if err != nil {
+			return fmt.Errorf("create games bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("CharactersByGame"))
+		if err != nil {
+			return fmt.Errorf("create characters by game bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("Relationships"))
+		if err != nil {
+			return fmt.Errorf("create relationships bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("RelationshipsByCharacter"))
+		if err != nil {
+			return fmt.Errorf("create relationships by character bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("CharacterTags"))
+		if err != nil {
+			return fmt.Errorf("create character tags bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("Tags"))
+		if err != nil {
+			return fmt.Errorf("create tags bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("Collections"))
+		if err != nil {
+			return fmt.Errorf("create collections bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("Favorites"))
+		if err != nil {
+			return fmt.Errorf("create favorites bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("FavoriteCounts"))
+		if err != nil {
+			return fmt.Errorf("create favorite counts bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("Comments"))
+		if err != nil {
+			return fmt.Errorf("create comments bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("CommentThreads"))
+		if err != nil {
+			return fmt.Errorf("create comment threads bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("PersistedQueries"))
+		if err != nil {
+			return fmt.Errorf("create persisted queries bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("PersistedQueriesOrder"))
+		if err != nil {
+			return fmt.Errorf("create persisted queries order bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("PersistedManifestQueries"))
+		if err != nil {
+			return fmt.Errorf("create persisted manifest queries bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte("LinkStatus"))
+		if err != nil {
+			return fmt.Errorf("create link status bucket: %s", err)
+		}
+
 		return nil
 	})
 
-	db.Close()
+	data.SetDB(db)
+	if flag.Arg(0) == "migrate" {
+		check(migrateDatabase(true))
+		return
+	}
+	check(migrateDatabase(*migrateOnStart))
+
+	if flag.Arg(0) == "import" || flag.Arg(0) == "import-wiki" {
+		importer := importFile
+		if flag.Arg(0) == "import-wiki" {
+			importer = importWiki
+		}
+		if err := importer(flag.Args()[1:]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	validate = validator.New()
 
+	data.StartTrashPurger(*trashRetention, trashPurgeInterval)
+	data.SetModerators(strings.Split(*moderators, ","))
+	data.SetAdmins(strings.Split(*admins, ","))
+
+	if *backupDir != "" {
+		check(backup.Schedule(db, *backupDir, *backupInterval, *backupKeep))
+	}
+	if *linkCheckInterval > 0 {
+		data.StartLinkChecker(linkcheck.New(*linkCheckConcurrency), *linkCheckInterval)
+	}
+
 	store.Options = &sessions.Options{
 		Path:     "/",
 		HttpOnly: true,
 		MaxAge:   86400 * 30,
 	}
 
-	http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write(page)
-	}))
-
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000"},
+		AllowedOrigins:   allowedOrigins,
 		AllowCredentials: true,
 	})
 
@@ -338,48 +737,55 @@ func main() {
 
 	http.Handle("/auth/logout", gorillaContext.ClearHandler(c.Handler(http.HandlerFunc(logoutHandler))))
 
-	http.Handle("/graphql", gorillaContext.ClearHandler(
-		c.Handler(authHandler(&apollo.Handler{Schema: schema}))))
-	http.Handle("/graphiql", &relay.Handler{Schema: schema})
+	checker := limits.NewChecker(schema, limits.Config{
+		MaxComplexity:   *maxQueryComplexity,
+		DefaultListSize: defaultListSize,
+		FieldCosts:      data.FieldCosts,
+	})
+
+	persistedQueries := persisted.NewStore(db, *persistedQueriesOnly)
+	persistedQueries.MaxQuerySize = *persistedQueriesMaxSize
+	persistedQueries.MaxQueries = *persistedQueriesMax
+	if *persistedQueriesManifest != "" {
+		count, err := persistedQueries.LoadManifest(*persistedQueriesManifest)
+		check(err)
+		fmt.Printf("Registered %d persisted queries.\n", count)
+	}
+
+	graphqlHandler := &batchHandler{
+		Schema:           schema,
+		PersistedQueries: persistedQueries,
+		Limits:           checker,
+		MaxBatchSize:     *maxBatchSize,
+		MaxBodySize:      *maxRequestSize,
+		Timeout:          *requestTimeout,
+	}
+	http.Handle("/graphql", gorillaContext.ClearHandler(c.Handler(authHandler(
+		newSubscriptionHandler(schema, persistedQueries, checker, allowedOrigins, graphqlHandler)))))
+
+	http.Handle("/export", gorillaContext.ClearHandler(c.Handler(authHandler(http.HandlerFunc(exportHandler)))))
+
+	// Backups are streamed while the server is running, ?gzip=true compresses them
+	http.Handle("/admin/backup", gorillaContext.ClearHandler(authHandler(adminHandler(backup.Handler(db)))))
+
+	if *graphiqlEnabled {
+		page, err := staticFiles.ReadFile("static/graphiql.html")
+		check(err)
+		assets, err := graphiqlAssets()
+		check(err)
+
+		// The explorer sends its queries to /graphql, so they pass
+		// through the same session handling and limits as the frontend
+		http.Handle("/graphiql", gorillaContext.ClearHandler(c.Handler(authHandler(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write(page)
+			})))))
+		http.Handle("/graphiql/assets/", http.StripPrefix("/graphiql/assets/",
+			http.FileServer(http.FS(assets))))
+		http.Handle("/", http.RedirectHandler("/graphiql", http.StatusFound))
+	}
 
 	fmt.Println("Running Server on port 8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
-
-var page = []byte(`
-<!DOCTYPE html>
-<html>
-	<head>
-		<link rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/graphiql/0.10.2/graphiql.css" />
-		<script src="https://cdnjs.cloudflare.com/ajax/libs/fetch/1.1.0/fetch.min.js"></script>
-		<script src="https://cdnjs.cloudflare.com/ajax/libs/react/15.5.4/react.min.js"></script>
-		<script src="https://cdnjs.cloudflare.com/ajax/libs/react/15.5.4/react-dom.min.js"></script>
-		<script src="https://cdnjs.cloudflare.com/ajax/libs/graphiql/0.11.5/graphiql.js"></script>
-	</head>
-	<body style="width: 100%; height: 100%; margin: 0; overflow: hidden;">
-		<div id="graphiql" style="height: 100vh;">Loading...</div>
-		<script>
-			function graphQLFetcher(graphQLParams) {
-				return fetch("/graphiql", {
-					method: "post",
-					body: JSON.stringify(graphQLParams),
-					credentials: "include",
-				}).then(function (response) {
-					return response.text();
-				}).then(function (responseBody) {
-					try {
-						return JSON.parse(responseBody);
-					} catch (error) {
-						return responseBody;
-					}
-				});
-			}
-
-			ReactDOM.render(
-				React.createElement(GraphiQL, {fetcher: graphQLFetcher}),
-				document.getElementById("graphiql")
-			);
-		</script>
-	</body>
-</html>
-`)
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fusion44/gamechars-server/data"
+	"github.com/fusion44/gamechars-server/limits"
+	"github.com/fusion44/gamechars-server/persisted"
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// Message types of the graphql-ws protocol used by apollo's subscriptions-transport-ws
+// https://github.com/apollographql/subscriptions-transport-ws/blob/master/PROTOCOL.md
+const (
+	gqlConnectionInit      = "connection_init"
+	gqlConnectionAck       = "connection_ack"
+	gqlConnectionError     = "connection_error"
+	gqlConnectionKeepAlive = "ka"
+	gqlConnectionTerminate = "connection_terminate"
+	gqlStart               = "start"
+	gqlData                = "data"
+	gqlError               = "error"
+	gqlComplete            = "complete"
+	gqlStop                = "stop"
+)
+
+const keepAliveInterval = 15 * time.Second
+
+// operationMessage is the envelope of all graphql-ws messages
+type operationMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// subscriptionHandler serves GraphQL subscriptions over WebSocket using the
+// graphql-ws protocol. All other requests are passed on to next, so queries,
+// mutations and subscriptions can share one endpoint.
+type subscriptionHandler struct {
+	schema    *graphql.Schema
+	persisted *persisted.Store
+	limits    *limits.Checker
+	upgrader  websocket.Upgrader
+	next      http.Handler
+}
+
+func newSubscriptionHandler(schema *graphql.Schema, store *persisted.Store, checker *limits.Checker,
+	allowedOrigins []string, next http.Handler) *subscriptionHandler {
+	return &subscriptionHandler{
+		schema:    schema,
+		persisted: store,
+		limits:    checker,
+		next:      next,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{"graphql-ws"},
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				if origin == "" || origin == "http://"+r.Host {
+					return true
+				}
+				for _, allowed := range allowedOrigins {
+					if origin == allowed {
+						return true
+					}
+				}
+				return false
+			},
+		},
+	}
+}
+
+func (h *subscriptionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		fmt.Println(err.Error())
+		return
+	}
+	defer ws.Close()
+
+	// The request context carries the AuthData of the session,
+	// so every subscription only sees what the user may see
+	conn := &subscriptionConn{
+		ws:         ws,
+		schema:     h.schema,
+		persisted:  h.persisted,
+		limits:     h.limits,
+		operations: make(map[string]*operation),
+	}
+	conn.serve(r.Context())
+}
+
+// subscriptionConn is a single WebSocket connection of a client
+type subscriptionConn struct {
+	ws        *websocket.Conn
+	schema    *graphql.Schema
+	persisted *persisted.Store
+	limits    *limits.Checker
+
+	// writeMutex serializes writes as only one writer is allowed at a time
+	writeMutex sync.Mutex
+
+	// keepAliveOnce starts sending keep alive messages on the first connection_init
+	keepAliveOnce sync.Once
+
+	operationsMutex sync.Mutex
+	operations      map[string]*operation
+}
+
+// operation is a running operation of a client. Its address identifies it,
+// so an operation which ends can't cancel a newer one with the same ID.
+type operation struct {
+	cancel context.CancelFunc
+}
+
+func (c *subscriptionConn) send(id string, msgType string, payload interface{}) {
+	msg := operationMessage{ID: id, Type: msgType}
+	if payload != nil {
+		p, err := json.Marshal(payload)
+		if err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		msg.Payload = p
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if err := c.ws.WriteJSON(msg); err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+func (c *subscriptionConn) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for {
+		var msg operationMessage
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				fmt.Println(err.Error())
+			}
+			return
+		}
+
+		switch msg.Type {
+		case gqlConnectionInit:
+			c.send("", gqlConnectionAck, nil)
+			c.keepAliveOnce.Do(func() { go c.keepAlive(ctx) })
+		case gqlStart:
+			c.start(ctx, msg)
+		case gqlStop:
+			c.stop(msg.ID)
+		case gqlConnectionTerminate:
+			return
+		default:
+			c.send(msg.ID, gqlConnectionError, map[string]string{
+				"message": fmt.Sprintf("Unknown message type %s", msg.Type),
+			})
+		}
+	}
+}
+
+func (c *subscriptionConn) keepAlive(ctx context.Context) {
+	c.send("", gqlConnectionKeepAlive, nil)
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.send("", gqlConnectionKeepAlive, nil)
+		}
+	}
+}
+
+// start runs an operation and sends all its results to the client until
+// the operation completes or the client stops it
+func (c *subscriptionConn) start(ctx context.Context, msg operationMessage) {
+	var req graphqlRequest
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		c.send(msg.ID, gqlError, map[string]string{"message": "Unable to decode operation"})
+		return
+	}
+
+	if errs := checkRequest(c.persisted, c.limits, &req); len(errs) > 0 {
+		c.send(msg.ID, gqlError, errs[0])
+		return
+	}
+
+	// Restarting an operation with the same ID replaces the old one
+	c.stop(msg.ID)
+
+	// The connection lives much longer than a single request, so values
+	// cached by the loaders of the connection would become stale
+	opCtx, cancel := context.WithCancel(data.WithoutLoaders(ctx))
+	op := &operation{cancel}
+	c.operationsMutex.Lock()
+	c.operations[msg.ID] = op
+	c.operationsMutex.Unlock()
+
+	results, err := c.schema.Subscribe(opCtx, req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		c.send(msg.ID, gqlError, map[string]string{"message": err.Error()})
+		c.finish(msg.ID, op)
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-opCtx.Done():
+				return
+			case result, ok := <-results:
+				if !ok {
+					c.send(msg.ID, gqlComplete, nil)
+					c.finish(msg.ID, op)
+					return
+				}
+				c.send(msg.ID, gqlData, result)
+			}
+		}
+	}()
+}
+
+// stop cancels the operation the client started with the given ID
+func (c *subscriptionConn) stop(id string) {
+	c.operationsMutex.Lock()
+	defer c.operationsMutex.Unlock()
+
+	if op, ok := c.operations[id]; ok {
+		op.cancel()
+		delete(c.operations, id)
+	}
+}
+
+// finish cancels an operation which ended by itself. The client may
+// already have restarted the ID, then only the old operation is cancelled.
+func (c *subscriptionConn) finish(id string, op *operation) {
+	c.operationsMutex.Lock()
+	defer c.operationsMutex.Unlock()
+
+	op.cancel()
+	if c.operations[id] == op {
+		delete(c.operations, id)
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownCode   = regexp.MustCompile("`([^`]+)`")
+	markdownBold   = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	markdownItalic = regexp.MustCompile(`\*([^*]+)\*`)
+	markdownLink   = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^\s)]+)\)`)
+	blankLines     = regexp.MustCompile(`\n\s*\n`)
+)
+
+// RenderMarkdown renders a small, safe subset of markdown to HTML.
+// All HTML in the input is escaped first, so user input can never
+// inject markup. Supported are paragraphs, line breaks, `code`,
+// **bold**, *italic* and [links](https://example.com).
+func RenderMarkdown(input string) string {
+	input = strings.Replace(strings.TrimSpace(input), "\r\n", "\n", -1)
+	if input == "" {
+		return ""
+	}
+
+	var paragraphs []string
+	for _, p := range blankLines.Split(input, -1) {
+		paragraphs = append(paragraphs, "<p>"+renderInline(p)+"</p>")
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+func renderInline(text string) string {
+	text = html.EscapeString(text)
+
+	// Code spans are rendered verbatim, so take them out before
+	// the other rules are applied and put them back afterwards.
+	var codeSpans []string
+	text = markdownCode.ReplaceAllStringFunc(text, func(m string) string {
+		codeSpans = append(codeSpans, "<code>"+markdownCode.FindStringSubmatch(m)[1]+"</code>")
+		return "\x00"
+	})
+
+	text = markdownLink.ReplaceAllString(text, `<a href="$2" rel="nofollow noopener">$1</a>`)
+	text = markdownBold.ReplaceAllString(text, "<strong>$1</strong>")
+	text = markdownItalic.ReplaceAllString(text, "<em>$1</em>")
+	text = strings.Replace(text, "\n", "<br>", -1)
+
+	for _, code := range codeSpans {
+		text = strings.Replace(text, "\x00", code, 1)
+	}
+	return text
+}
@@ -0,0 +1,146 @@
+// Package wiki reads MediaWiki XML exports as created by Special:Export or
+// the database dumps of Wikipedia. The dump is streamed, so it doesn't need
+// to fit into memory.
+package wiki
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Page is the latest revision of an article in the dump
+type Page struct {
+	Title string
+	// Revision is the ID of the revision, Timestamp its creation time
+	Revision  string
+	Timestamp time.Time
+	Text      string
+}
+
+type xmlSiteInfo struct {
+	Base string `xml:"base"`
+}
+
+type xmlPage struct {
+	Title     string        `xml:"title"`
+	Namespace int           `xml:"ns"`
+	Revisions []xmlRevision `xml:"revision"`
+}
+
+type xmlRevision struct {
+	ID        string `xml:"id"`
+	Timestamp string `xml:"timestamp"`
+	Text      string `xml:"text"`
+}
+
+// Dump reads the pages of a MediaWiki XML export
+type Dump struct {
+	decoder *xml.Decoder
+	// Site is the URL of the wiki articles, e.g. https://en.wikipedia.org/wiki/
+	Site string
+}
+
+// NewDump starts reading a dump
+func NewDump(r io.Reader) *Dump {
+	return &Dump{decoder: xml.NewDecoder(r), Site: "https://en.wikipedia.org/wiki/"}
+}
+
+// Next returns the next article of the dump. Pages of other namespaces
+// like talk or user pages are skipped. Returns io.EOF at the end.
+func (d *Dump) Next() (*Page, error) {
+	for {
+		token, err := d.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "siteinfo":
+			var info xmlSiteInfo
+			if err := d.decoder.DecodeElement(&info, &start); err != nil {
+				return nil, errors.Wrap(err, "Unable to read the site info")
+			}
+			// The base is the main page, e.g. https://en.wikipedia.org/wiki/Main_Page
+			if i := strings.LastIndex(info.Base, "/"); i >= 0 {
+				d.Site = info.Base[:i+1]
+			}
+		case "page":
+			var p xmlPage
+			if err := d.decoder.DecodeElement(&p, &start); err != nil {
+				return nil, errors.Wrap(err, "Unable to read a page")
+			}
+			if p.Namespace != 0 || len(p.Revisions) == 0 {
+				continue
+			}
+
+			// Exports can contain the history of a page, the last revision is the newest
+			rev := p.Revisions[len(p.Revisions)-1]
+			timestamp, err := time.Parse(time.RFC3339, rev.Timestamp)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Unable to read the date of revision %s of %s", rev.ID, p.Title)
+			}
+			return &Page{Title: p.Title, Revision: rev.ID, Timestamp: timestamp, Text: rev.Text}, nil
+		}
+	}
+}
+
+// URL returns the URL of an article of the wiki
+func (d *Dump) URL(title string) string {
+	u := url.URL{Path: strings.Replace(title, " ", "_", -1)}
+	return d.Site + u.EscapedPath()
+}
+
+// FileURL returns a URL which redirects to a file of the wiki
+func (d *Dump) FileURL(name string) string {
+	return d.URL("Special:FilePath/" + name)
+}
+
+// NormalizeTitle converts a title to the form MediaWiki stores it in,
+// with spaces instead of underscores and an uppercase first letter
+func NormalizeTitle(title string) string {
+	title = strings.TrimSpace(strings.Replace(title, "_", " ", -1))
+	first, size := utf8.DecodeRuneInString(title)
+	if size == 0 {
+		return ""
+	}
+	return string(unicode.ToUpper(first)) + title[size:]
+}
+
+// TitleFromURL extracts the title of an article from its URL.
+// Returns an empty title if the URL doesn't point to an article.
+func TitleFromURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	i := strings.Index(u.Path, "/wiki/")
+	if i < 0 {
+		return ""
+	}
+	return NormalizeTitle(u.Path[i+len("/wiki/"):])
+}
+
+// InCategory checks whether the page is in the given category
+func (p *Page) InCategory(category string) bool {
+	category = NormalizeTitle(category)
+	for _, link := range links(p.Text) {
+		parts := strings.SplitN(link, "|", 2)
+		target := strings.TrimSpace(parts[0])
+		if len(target) > len("Category:") && strings.EqualFold(target[:len("Category:")], "Category:") &&
+			NormalizeTitle(target[len("Category:"):]) == category {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package wiki
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Infobox holds the fields of a character infobox
+type Infobox struct {
+	Name      string
+	FirstGame string
+	// FirstYear is the year in parentheses after the first game, 0 if missing
+	FirstYear int32
+	Creator   string
+	// Image is the name of the file without the File: prefix
+	Image string
+}
+
+// infoboxFields maps the parameters of the infobox templates to the fields.
+// Older revisions of the templates use other names.
+var infoboxFields = map[string]string{
+	"name":             "name",
+	"first":            "first",
+	"first_game":       "first",
+	"firstgame":        "first",
+	"first_appearance": "first",
+	"creator":          "creator",
+	"created_by":       "creator",
+	"image":            "image",
+}
+
+var (
+	refPattern     = regexp.MustCompile(`(?s)<ref[^>]*/>|<ref[^>]*>.*?</ref>`)
+	commentPattern = regexp.MustCompile(`(?s)<!--.*?-->`)
+	breakPattern   = regexp.MustCompile(`(?i)\s*<br\s*/?>\s*`)
+	tagPattern     = regexp.MustCompile(`<[^>]+>`)
+	yearPattern    = regexp.MustCompile(`\((\d{4})\)\s*$`)
+	spacePattern   = regexp.MustCompile(`\s+`)
+)
+
+// scan calls fn for every template ({{...}}) and link ([[...]]) at the top
+// level of the text with its content and the position after it
+func scan(text string, fn func(open string, content string, end int)) {
+	for i := 0; i+1 < len(text); i++ {
+		open := text[i : i+2]
+		if open != "{{" && open != "[[" {
+			continue
+		}
+		if end := matching(text, i); end > 0 {
+			fn(open, text[i+2:end-2], end)
+			i = end - 1
+		}
+	}
+}
+
+// matching returns the position after the closing brackets of the
+// template or link starting at start, -1 if it isn't closed
+func matching(text string, start int) int {
+	depth := 0
+	for i := start; i+1 < len(text); i++ {
+		switch text[i : i+2] {
+		case "{{", "[[":
+			depth++
+			i++
+		case "}}", "]]":
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// links returns the contents of the links at the top level of the text
+func links(text string) []string {
+	var result []string
+	scan(text, func(open string, content string, end int) {
+		if open == "[[" {
+			result = append(result, content)
+		}
+	})
+	return result
+}
+
+// splitParams splits the content of a template at the pipes which
+// are not part of a nested template or link
+func splitParams(content string) []string {
+	var params []string
+	depth := 0
+	last := 0
+	for i := 0; i < len(content); i++ {
+		switch {
+		case strings.HasPrefix(content[i:], "{{") || strings.HasPrefix(content[i:], "[["):
+			depth++
+			i++
+		case strings.HasPrefix(content[i:], "}}") || strings.HasPrefix(content[i:], "]]"):
+			depth--
+			i++
+		case content[i] == '|' && depth == 0:
+			params = append(params, content[last:i])
+			last = i + 1
+		}
+	}
+	return append(params, content[last:])
+}
+
+// ParseInfobox extracts the fields of the first infobox of the page.
+// Returns nil if the page has no infobox.
+func (p *Page) ParseInfobox() *Infobox {
+	text := commentPattern.ReplaceAllString(p.Text, "")
+
+	var box *Infobox
+	scan(text, func(open string, content string, end int) {
+		if box != nil || open != "{{" {
+			return
+		}
+		params := splitParams(content)
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(params[0])), "infobox") {
+			return
+		}
+
+		box = &Infobox{}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			key := strings.ToLower(strings.Replace(strings.TrimSpace(kv[0]), " ", "_", -1))
+			value := kv[1]
+
+			switch infoboxFields[key] {
+			case "name":
+				box.Name = Clean(value)
+			case "first":
+				box.FirstGame = Clean(value)
+				if m := yearPattern.FindStringSubmatch(box.FirstGame); m != nil {
+					year, _ := strconv.Atoi(m[1])
+					box.FirstYear = int32(year)
+					box.FirstGame = strings.TrimSpace(box.FirstGame[:len(box.FirstGame)-len(m[0])])
+				}
+			case "creator":
+				box.Creator = Clean(value)
+			case "image":
+				box.Image = imageName(value)
+			}
+		}
+	})
+	return box
+}
+
+// imageName extracts the file name of an image parameter, which is
+// either a plain file name or a link to the file
+func imageName(value string) string {
+	value = strings.TrimSpace(value)
+	if l := links(value); len(l) > 0 {
+		value = strings.SplitN(l[0], "|", 2)[0]
+	}
+	for _, prefix := range []string{"File:", "Image:"} {
+		if len(value) >= len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			value = value[len(prefix):]
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// Clean converts wikitext to plain text. Links are replaced by their
+// label, templates, references, comments and HTML tags are removed.
+func Clean(text string) string {
+	text = commentPattern.ReplaceAllString(text, "")
+	text = refPattern.ReplaceAllString(text, "")
+
+	var b strings.Builder
+	last := 0
+	scan(text, func(open string, content string, end int) {
+		start := end - len(content) - 4
+		b.WriteString(text[last:start])
+		last = end
+		if open == "[[" {
+			parts := strings.Split(content, "|")
+			b.WriteString(Clean(parts[len(parts)-1]))
+		}
+	})
+	b.WriteString(text[last:])
+
+	// Lists in infoboxes are often separated by line breaks
+	text = breakPattern.ReplaceAllString(b.String(), ", ")
+	text = tagPattern.ReplaceAllString(text, " ")
+	text = strings.Replace(text, "'''", "", -1)
+	text = strings.Replace(text, "''", "", -1)
+	text = strings.Replace(text, "&nbsp;", " ", -1)
+	return strings.TrimSpace(spacePattern.ReplaceAllString(text, " "))
+}